@@ -1,3 +1,8 @@
 module github.com/christianraza/gop
 
 go 1.16
+
+require (
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
+	lukechampine.com/blake3 v1.1.7
+)
@@ -0,0 +1,16 @@
+// Command gop packages Go projects with gox and releases them to Github with gh, see package gop to embed it
+package main
+
+import (
+	"os"
+
+	"github.com/christianraza/gop/pkg/gop"
+)
+
+// gop's own version, set with: go build -ldflags "-X main.gopVersion=<version>"
+var gopVersion string
+
+func main() {
+	gop.Version = gopVersion
+	os.Exit(gop.Main(os.Args[1:]))
+}
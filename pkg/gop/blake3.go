@@ -0,0 +1,17 @@
+//go:build blake3
+// +build blake3
+
+package gop
+
+import (
+	"hash"
+
+	"lukechampine.com/blake3"
+)
+
+// BLAKE3 checksums, optional so gop has no dependencies by default
+func init() {
+	checksumHashes["blake3"] = func() hash.Hash {
+		return blake3.New(32, nil)
+	}
+}
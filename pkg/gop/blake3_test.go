@@ -0,0 +1,26 @@
+//go:build blake3
+// +build blake3
+
+package gop
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlake3Checksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty")
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fileChecksum(path, "blake3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// BLAKE3 of empty input
+	want := "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"
+	if got != want {
+		t.Errorf("fileChecksum() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!windows
+
+package gop
+
+import "errors"
+
+// Free space isn't checked on this platform
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New("free space unsupported on this platform")
+}
@@ -0,0 +1,4132 @@
+// Package gop packages Go projects with gox and releases them to Github with gh.
+//
+// Pack and Release run like the gop command with -p and -r, Main runs the command itself.
+// Runs share package state, so only one may run at a time.
+package gop
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/build"
+	"hash"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Configuration
+const (
+	// Distributions directory
+	distDir = "dist"
+	// Binaries directory
+	binDir = "bin"
+	// Changelog name
+	logName = "CHANGELOG.md"
+	// Packaged license directory
+	packLicDir = "licenses-and-notices"
+	// Packaged readme name
+	packReadmeName = "readme.txt"
+	// Packaged aggregated notices name
+	packNoticesName = "THIRD-PARTY-NOTICES.txt"
+	// Packaged checksums name
+	packSumsName = "SHA256SUMS"
+	// Bundle name suffix, see -bundle
+	bundleName = "all-platforms.zip"
+	// Checksums name
+	checksumsName = "checksums.txt"
+	// Latest release manifest name
+	latestName = "latest.json"
+	// Build hash name, stored with the binaries
+	buildHashName = ".gop-build"
+	// Vendor directory
+	vendorDir = "vendor"
+	// Marks a vendor directory created by gop
+	vendorMarker = ".gop"
+	// Estimated archive size relative to binary size, archives are compressed but include extra files
+	archiveFactor = 1.1
+	// Module domain protocol
+	protocol = "https://"
+)
+
+// Files to ignore when traversing the walk directory
+var noWalk = map[string]struct{}{
+	"examples": {},
+	"assets":   {},
+	"dist":     {},
+	"bin":      {},
+	"src":      {},
+	".go":      {},
+}
+
+// gop's own version, the gop command sets it from: go build -ldflags "-X main.gopVersion=<version>"
+var Version string
+
+var version string
+var releaseFlag bool
+var changelog string
+var packFlag bool
+var prerelease bool
+var projectName string
+var modulePath string
+var goVersion string
+var hasDeps bool
+var licenseID string
+var commit string
+var banner bool
+var targets string
+var listTargets bool
+var excludeTargets string
+var printVersion bool
+var jsonFlag bool
+var noLicense bool
+var licenseName string
+var checksums bool
+var archiveTemplate string
+var latest bool
+var preBuild string
+var postPackage string
+var homebrew bool
+var scoop bool
+var linuxPackages bool
+var upx bool
+var targetCommitish string
+var allowDirty bool
+var createTag bool
+var ghRepo string
+var includes includeList
+var includeDirs includeList
+var timeout time.Duration
+var cosign bool
+var sbom bool
+var sbomFormat string
+var keepBin bool
+var cleanBin bool
+var versionSource string
+var checkTagMode string
+var noEmoji bool
+var storeExts string
+var readmeName string
+var aggregateNotices bool
+var noticesOnly bool
+var goxPath string
+var uploadConcurrency int
+var goxFlags string
+var format string
+var autoPrerelease bool
+var incremental bool
+var notesHeader string
+var licenseNaming string
+var single bool
+var keepReleases int
+var pruneStable bool
+var yes bool
+var labels labelList
+var modFile string
+var checkFlag bool
+var buildEnv envList
+var packing int32
+var host string
+var buildParallel int
+var archiveChecksums bool
+var bundle bool
+var bundleOnly bool
+var changelogTitles string
+var bumpCommit bool
+var autoNotes bool
+var versionedDist bool
+var outDir string
+var force bool
+var token string
+var keepTemp bool
+var changelogFormat string
+var windowsGUI bool
+var licenseDir string
+var minGo string
+var noReadme bool
+var checkLinks bool
+var strictLicense bool
+var nested bool
+var licenseExclude string
+var rootTemplate string
+var checksumAlgo string
+var ghaOutput string
+var resume bool
+var listAssets bool
+var projectDir string
+var repoURL string
+var workspace bool
+var notesFooter string
+var verbose bool
+
+var logErr *log.Logger = log.New(os.Stderr, "", 0)
+
+// Changes the entries of each package, see Options.ArchiveHook
+var archiveHook func(entries []ArchiveEntry) []ArchiveEntry
+
+type walkFunc func(root string, path string, info fs.FileInfo)
+
+// Built binary, named <dir>-<os>-<arch>[.exe] by gox
+type binary struct {
+	Name string
+	Base string
+	Ext  string
+	OS   string
+	Arch string
+}
+
+// Extra file to package, see -include
+type include struct {
+	From   string
+	To     string
+	Target string
+}
+
+type includeList []include
+
+func (l *includeList) String() string {
+	var a []string
+	for _, inc := range *l {
+		a = append(a, inc.From)
+	}
+	return strings.Join(a, ",")
+}
+
+// Parse <from>[:<to>][@<target>]
+func (l *includeList) Set(s string) error {
+	var inc include
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		inc.Target = s[i+1:]
+		s = s[:i]
+		if _, err := path.Match(inc.Target, ""); err != nil {
+			return err
+		}
+	}
+	// Ignore windows drive letters
+	if i := strings.LastIndex(s, ":"); i > 1 {
+		inc.To = s[i+1:]
+		s = s[:i]
+	}
+	inc.From = s
+	if inc.To == "" {
+		inc.To = filepath.Base(s)
+	}
+	if inc.From == "" {
+		return fmt.Errorf("missing file to include")
+	}
+	*l = append(*l, inc)
+	return nil
+}
+
+// Whether the include belongs in a binary's package, targets without a slash match the os only
+func (inc include) matches(bin binary) bool {
+	if inc.Target == "" {
+		return true
+	}
+	if !strings.Contains(inc.Target, "/") {
+		ok, _ := path.Match(inc.Target, bin.OS)
+		return ok
+	}
+	ok, _ := path.Match(inc.Target, bin.OS+"/"+bin.Arch)
+	return ok
+}
+
+// Upload label of matching assets, see -label
+type label struct {
+	Pattern string
+	Tmpl    *template.Template
+}
+
+type labelList []label
+
+func (l *labelList) String() string {
+	var a []string
+	for _, lb := range *l {
+		a = append(a, lb.Pattern)
+	}
+	return strings.Join(a, ",")
+}
+
+// Parse <pattern>=<label template>
+func (l *labelList) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected <pattern>=<label>")
+	}
+	pattern := s[:i]
+	if _, err := path.Match(pattern, ""); err != nil {
+		return err
+	}
+	tmpl, err := template.New("label").Parse(s[i+1:])
+	if err != nil {
+		return err
+	}
+	*l = append(*l, label{Pattern: pattern, Tmpl: tmpl})
+	return nil
+}
+
+// Label template data
+type labelData struct {
+	Name    string
+	Version string
+	OS      string
+	Arch    string
+}
+
+// Label of the asset from the first matching rule, empty if none match
+func (l labelList) label(name string) string {
+	for _, lb := range l {
+		if ok, _ := path.Match(lb.Pattern, name); !ok {
+			continue
+		}
+		data := labelData{Name: name, Version: version}
+		// Assets are named <dir>-<os>-<arch><ext>, neither os nor arch contain dots
+		a := strings.Split(name, "-")
+		if len(a) >= 3 {
+			data.OS = a[len(a)-2]
+			data.Arch = strings.SplitN(a[len(a)-1], ".", 2)[0]
+		}
+		var b strings.Builder
+		err := lb.Tmpl.Execute(&b, data)
+		if err != nil {
+			fatal(err)
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// Build environment variables, see -env
+type envList []string
+
+func (l *envList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Parse KEY=VALUE
+func (l *envList) Set(s string) error {
+	if i := strings.Index(s, "="); i <= 0 {
+		return fmt.Errorf("expected KEY=VALUE")
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+// Release manifest, see -latest
+type manifest struct {
+	Name    string    `json:"name"`
+	Version string    `json:"version"`
+	License string    `json:"license"`
+	Build   buildInfo `json:"build"`
+	// Algorithm of the asset checksums besides sha256
+	ChecksumAlgo string  `json:"checksum_algo,omitempty"`
+	Assets       []asset `json:"assets"`
+}
+
+// Build environment
+type buildInfo struct {
+	Go        string `json:"go"`
+	Toolchain string `json:"toolchain"`
+	Gop       string `json:"gop"`
+	Runtime   string `json:"runtime"`
+}
+
+// Packaged asset
+type asset struct {
+	Name   string `json:"name"`
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	// Checksum with -checksum-algo when it isn't sha256
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// Archive name template data, see -archive-template
+type archiveData struct {
+	Name    string
+	Version string
+	OS      string
+	Arch    string
+	License string
+	Commit  string
+}
+
+// Structured output event, see -json
+type event struct {
+	Event   string `json:"event"`
+	Asset   string `json:"asset,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+// Flags with their defaults on fs, shared by Main, Pack, Release and tests
+func defineFlags(fs *flag.FlagSet) {
+	// Repeatable flags start empty
+	labels, buildEnv, includes, includeDirs = nil, nil, nil, nil
+
+	fs.BoolVar(&releaseFlag, "r", false, "Release to Github")
+	fs.BoolVar(&packFlag, "p", false, "Package")
+	fs.BoolVar(&prerelease, "pre", false, "Mark as pre-release")
+	fs.BoolVar(&autoPrerelease, "auto-pre", true, "Mark as pre-release when the version is one, example: v1.2.0-rc.1")
+	fs.BoolVar(&verbose, "v", false, "Verbose output")
+	fs.DurationVar(&timeout, "timeout", 0, "Timeout for each external command, example: 10m (default none)")
+	fs.IntVar(&keepReleases, "keep", 5, "Number of newest releases kept by prune")
+	fs.BoolVar(&pruneStable, "prune-stable", false, "Also prune releases that aren't pre-releases")
+	fs.BoolVar(&yes, "yes", false, "Confirm destructive actions such as prune, and uploads with -list-assets")
+	fs.Var(&labels, "label", "Upload label of matching assets, repeatable: <pattern>=<label> with .Name, .Version, .OS and .Arch, example: \"*-linux-amd64.zip=Linux (x86-64)\"")
+	fs.IntVar(&uploadConcurrency, "upload-concurrency", 3, "Maximum number of assets uploaded at a time")
+	fs.StringVar(&host, "host", os.Getenv("GH_HOST"), "Github host for gh and generated URLs, example: github.example.com (default GH_HOST or the module path domain)")
+	fs.StringVar(&token, "token", "", "Release token passed to gh, never printed (default GOP_TOKEN)")
+	fs.StringVar(&ghRepo, "gh-repo", "", "Repository to release to, example: owner/name (default inferred by gh)")
+	fs.BoolVar(&listAssets, "list-assets", false, "List the assets and ask before uploading, -yes skips asking")
+	fs.BoolVar(&resume, "resume", false, "Upload only the assets missing from an existing release, keeping it when uploads fail")
+	fs.BoolVar(&force, "force", false, "Delete an existing release of the version and its tag before releasing, destructive")
+	fs.BoolVar(&createTag, "create-tag", false, "Create and push the version tag before releasing")
+	fs.BoolVar(&workspace, "workspace", false, "Run for every go.work module changed since the latest tag")
+	fs.StringVar(&projectDir, "dir", "", "Project root to run in, other paths are relative to it (default the working directory)")
+	fs.StringVar(&modFile, "metadata-mod", "go.mod", "Path of the go.mod read for the project name, go version and dependencies, builds use the project's own")
+	fs.StringVar(&changelogFormat, "changelog-format", "hash", "Changelog format: hash (# <version>), keep-a-changelog (## [<version>] - <date>) or git-auto (notes from commits)")
+	fs.StringVar(&changelogTitles, "changelog-titles", "Changelog,Changes,Change Log,Release Notes", "Comma separated changelog titles that aren't versions")
+	fs.StringVar(&versionSource, "version-source", "changelog", "Where to read the version from: changelog or git (latest tag)")
+	fs.StringVar(&minGo, "min-go", "fail", "Compare the local go toolchain against the go.mod go version: off, warn or fail")
+	fs.StringVar(&checkTagMode, "check-tag", "off", "Compare the changelog version against the latest git tag: off, warn or fail")
+	fs.BoolVar(&allowDirty, "allow-dirty", false, "Allow releasing with uncommitted changes")
+	fs.BoolVar(&autoNotes, "auto-notes", false, "Release notes from conventional commits since the previous tag instead of the changelog")
+	fs.BoolVar(&keepTemp, "keep-temp", false, "Keep the release notes file sent to gh and print its path")
+	fs.StringVar(&notesHeader, "notes-header", "", "File or text added before the release notes")
+	fs.StringVar(&notesFooter, "notes-footer", "", "File or text added after the release notes")
+	fs.StringVar(&targetCommitish, "target-commitish", "", "Branch or commit to create the release tag from (default the default branch)")
+	fs.StringVar(&targets, "targets", "", "Comma separated os/arch targets, example: linux/amd64,windows/amd64 (default gox's default platforms)")
+	fs.BoolVar(&single, "single", false, "Only build the host target, "+runtime.GOOS+"/"+runtime.GOARCH)
+	fs.StringVar(&excludeTargets, "exclude-targets", "", "Comma separated os/arch targets to exclude, example: js/wasm,aix/ppc64")
+	fs.BoolVar(&listTargets, "list-targets", false, "List targets and exit")
+	fs.BoolVar(&printVersion, "version", false, "Print gop version and exit")
+	fs.StringVar(&ghaOutput, "gha-output", os.Getenv("GITHUB_OUTPUT"), "Github Actions output file for the version, asset-count and assets (default GITHUB_OUTPUT)")
+	fs.BoolVar(&jsonFlag, "json", false, "Print JSON events instead of decorated output")
+	fs.BoolVar(&banner, "banner", false, "Print a banner with the project name and version")
+	fs.BoolVar(&noEmoji, "no-emoji", asciiTerminal(), "Print ASCII labels instead of emoji")
+	fs.BoolVar(&noLicense, "no-license", false, "Package without licenses and notices")
+	fs.StringVar(&licenseName, "license-name", "project", "Packaged project license name: project, original or <name>")
+	fs.StringVar(&licenseDir, "license-dir", packLicDir, "Packaged licenses and notices directory name")
+	fs.StringVar(&licenseNaming, "license-naming", "legacy", "Dependency license names: legacy (<parent>-<dir>-<name>) or module (<module path>_<name>)")
+	fs.BoolVar(&checksums, "checksums", false, "Write SHA256 checksums of packages to "+checksumsName)
+	fs.StringVar(&checksumAlgo, "checksum-algo", "sha256", "Checksum algorithm of "+checksumsName+" and "+latestName+": sha256, sha512 or blake3 (built with -tags blake3)")
+	fs.BoolVar(&archiveChecksums, "archive-checksums", false, "Package SHA256 checksums of the packaged files in "+packSumsName)
+	fs.StringVar(&goxPath, "gox-path", "", "Path of the gox executable (default gox in PATH or GOPATH)")
+	fs.IntVar(&buildParallel, "build-parallel", runtime.NumCPU(), "Number of targets built at a time")
+	fs.BoolVar(&incremental, "incremental", false, "Keep binaries between runs and skip building when sources are unchanged")
+	fs.Var(&buildEnv, "env", "Build environment variable, repeatable: KEY=VALUE, example: CGO_ENABLED=0")
+	fs.BoolVar(&windowsGUI, "windows-gui", false, "Build windows targets as GUI applications without a console window")
+	fs.StringVar(&goxFlags, "gox-flags", "", "Extra gox flags split like a shell, advanced and unsupported, example: \"-cgo -ldflags='-s -w'\"")
+	fs.BoolVar(&checkFlag, "check", false, "Run go vet and go test before packaging or releasing")
+	fs.StringVar(&preBuild, "pre-build", "", "Shell command to run before building, example: \"go generate ./...\"")
+	fs.StringVar(&postPackage, "post-package", "", "Shell command to run after packaging, with GOP_DIST_DIR and GOP_VERSION set")
+	fs.BoolVar(&homebrew, "homebrew", false, "Write a Homebrew formula for the darwin and linux packages")
+	fs.BoolVar(&scoop, "scoop", false, "Write a Scoop manifest for the windows/amd64 package")
+	fs.BoolVar(&bumpCommit, "bump-commit", false, "Commit the changelog after bumping the version")
+	fs.BoolVar(&versionedDist, "versioned-dist", false, "Package into "+distDir+"/<version>, keeping other versions")
+	fs.BoolVar(&keepBin, "keep-bin", false, "Keep the "+binDir+" directory after packaging (default)")
+	fs.BoolVar(&cleanBin, "clean-bin", false, "Remove the "+binDir+" directory after packaging")
+	fs.BoolVar(&noReadme, "no-readme", false, "Package without the readme")
+	fs.BoolVar(&nested, "nested", false, "Package files in a top-level directory named after the archive")
+	fs.StringVar(&rootTemplate, "root-template", "", "Top-level directory name template with -nested, same fields as -archive-template, example: {{.Name}}-{{.Version}} (default archive name)")
+	fs.StringVar(&licenseExclude, "license-exclude", "", "Vendored license paths to leave out, comma separated patterns, example: vendor/github.com/stretchr/*")
+	fs.BoolVar(&strictLicense, "strict-license", false, "Fail instead of warning when the project or dependency licenses are missing")
+	fs.BoolVar(&checkLinks, "check-links", false, "Warn when the readme source code URL isn't reachable")
+	fs.StringVar(&repoURL, "repo-url", "", "Source code URL in the readme, example: https://github.com/<owner>/<name> (default https://<module path>)")
+	fs.StringVar(&readmeName, "readme-name", packReadmeName, "Packaged readme name")
+	fs.BoolVar(&aggregateNotices, "notices", false, "Package dependency notices aggregated in "+packNoticesName)
+	fs.BoolVar(&noticesOnly, "notices-only", false, "Package only the aggregated notices, not each notice")
+	fs.StringVar(&storeExts, "store-ext", ".7z,.bz2,.gif,.gz,.jpeg,.jpg,.mp3,.mp4,.png,.webp,.woff2,.xz,.zip", "Comma separated extensions packaged without compression")
+	fs.Var(&includeDirs, "include-dir", "Extra directory to package recursively, repeatable: <from>[:<to>][@<os>/<arch> pattern], example: share:share")
+	fs.Var(&includes, "include", "Extra file to package, repeatable: <from>[:<to>][@<os>/<arch> pattern], example: install.bat@windows/*")
+	fs.BoolVar(&upx, "upx", false, "Compress binaries with UPX before packaging")
+	fs.BoolVar(&linuxPackages, "linux-packages", false, "Build deb and rpm packages for linux binaries with nfpm")
+	fs.BoolVar(&cosign, "cosign", false, "Sign packages with cosign keyless signing")
+	fs.BoolVar(&sbom, "sbom", false, "Write a software bill of materials, with syft if installed")
+	fs.StringVar(&sbomFormat, "sbom-format", "cyclonedx", "Software bill of materials format: cyclonedx or spdx")
+	fs.BoolVar(&bundle, "bundle", false, "Zip every asset into <project>-<version>-"+bundleName)
+	fs.BoolVar(&bundleOnly, "bundle-only", false, "Remove the target archives zipped into the bundle")
+	fs.BoolVar(&latest, "latest", false, "Write a "+latestName+" manifest describing the packaged release")
+	fs.StringVar(&format, "format", "zip", "Archive format: zip, tar.gz, gz (the binary alone) or auto (zip for windows, tar.gz otherwise)")
+	fs.StringVar(&archiveTemplate, "archive-template", "", "Archive name template with .Name, .Version, .OS, .Arch, .License and .Commit, example: {{.Name}}_{{.Version}}_{{.OS}}_{{.Arch}} (default <binary>)")
+}
+
+// Options of Pack and Release, empty fields keep the defaults of the gop command
+type Options struct {
+	// Project root, default the working directory
+	Dir string
+	// Targets as <os>/<arch>, default the ones gox builds
+	Targets []string
+	// Archive format: zip, tar.gz, gz or auto, default zip
+	Format string
+	// Upload the packaged assets when releasing, like gop -p -r
+	Assets bool
+	// Verbose output
+	Verbose bool
+	// Other flags of the gop command, example: []string{"-checksums", "-version-source", "git"}
+	Flags []string
+	// Called with the entries of each zip or tar.gz package before it's written, returning the entries to write.
+	// Packages are written concurrently so it may be called from several goroutines at once.
+	ArchiveHook func(entries []ArchiveEntry) []ArchiveEntry
+}
+
+// Set the flags of the non-empty fields
+func (o Options) set(fs *flag.FlagSet) error {
+	values := map[string]string{}
+	if o.Dir != "" {
+		values["dir"] = o.Dir
+	}
+	if len(o.Targets) > 0 {
+		values["targets"] = strings.Join(o.Targets, " ")
+	}
+	if o.Format != "" {
+		values["format"] = o.Format
+	}
+	if o.Verbose {
+		values["v"] = "true"
+	}
+	for name, value := range values {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Pack builds and packages the project into dist like gop -p
+func Pack(opts Options) error {
+	return run(opts, func() {
+		packFlag = true
+		prepare()
+		pack()
+	})
+}
+
+// Release creates the Github release of the version like gop -r, with the packaged assets when opts.Assets is set
+func Release(opts Options) error {
+	return run(opts, func() {
+		releaseFlag = true
+		packFlag = opts.Assets
+		prepare()
+		release(outDir)
+	})
+}
+
+// Main runs the gop command with its arguments, without the program name, and returns the exit code
+func Main(args []string) (code int) {
+	var err error
+	defer func() {
+		if e, ok := err.(exitError); ok {
+			report(e)
+			code = e.code
+		}
+	}()
+	defer catch(&err)
+
+	defineFlags(flag.CommandLine)
+	flag.CommandLine.Parse(args)
+	checkFlags()
+	enterProjectDir()
+
+	// Subcommands
+	switch flag.Arg(0) {
+	case "verify":
+		dir := flag.Arg(1)
+		if dir == "" {
+			dir = distDir
+		}
+		verify(dir)
+		return 0
+	case "clean":
+		clean()
+		return 0
+	case "prune":
+		prune()
+		return 0
+	case "bump":
+		bump(flag.Arg(1))
+		return 0
+	}
+
+	// Print version
+	if printVersion {
+		fmt.Printf("gop %s (%s %s/%s)\n", gopVersionString(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		return 0
+	}
+
+	// List targets
+	if listTargets {
+		for _, t := range resolveTargets() {
+			fmt.Println(t)
+		}
+		return 0
+	}
+
+	// Run for every changed workspace module
+	if workspace {
+		if _, err := os.Stat("go.work"); err == nil {
+			runWorkspace(args)
+			return 0
+		}
+		vprintf("No go.work, running for the module\n")
+	}
+
+	// Clean up interrupted runs
+	handleSignals()
+
+	// Check the project before anything is built
+	prepare()
+
+	// Package binaries
+	if packFlag {
+		pack()
+	}
+
+	// Release
+	if releaseFlag {
+		release(outDir)
+	}
+
+	// Github Actions step outputs
+	if ghaOutput != "" {
+		writeGitHubOutput(ghaOutput)
+	}
+	return 0
+}
+
+// Run Pack or Release with opts on fresh flags and state, returning to the working directory after
+func run(opts Options, f func()) (err error) {
+	defer func() {
+		// Ends that aren't failures, such as having no assets
+		if e, ok := err.(exitError); ok && e.msg == "" {
+			err = nil
+		}
+	}()
+	defer catch(&err)
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+
+	fs := flag.NewFlagSet("gop", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	defineFlags(fs)
+	if err := fs.Parse(opts.Flags); err != nil {
+		return err
+	}
+	if err := opts.set(fs); err != nil {
+		return err
+	}
+	// State of a previous run
+	version, changelog, commit, licenseID = "", "", "", ""
+	hasDeps = false
+	archiveHook = opts.ArchiveHook
+
+	checkFlags()
+	enterProjectDir()
+	f()
+	return nil
+}
+
+// Check flag combinations and values, some are adjusted to what they imply
+func checkFlags() {
+	if bundleOnly {
+		if latest || homebrew || scoop || cosign {
+			fatal("Please use -bundle-only without -latest, -homebrew, -scoop and -cosign, they describe the removed archives")
+		}
+		bundle = true
+	}
+	// Read here so the token isn't shown as a flag default
+	if token == "" {
+		token = os.Getenv("GOP_TOKEN")
+	}
+	if keepBin && cleanBin {
+		fatal("Please use either -keep-bin or -clean-bin")
+	}
+	if !contains([]string{"zip", "tar.gz", "gz", "auto"}, format) {
+		fatalf("Unknown archive format: %s\n", format)
+	}
+	if _, err := splitArgs(goxFlags); err != nil {
+		fatalf("Could not parse -gox-flags: %v\n", err)
+	}
+	if uploadConcurrency < 1 {
+		fatal("Upload concurrency must be at least 1")
+	}
+	if buildParallel < 1 {
+		fatal("Build parallelism must be at least 1")
+	}
+	if keepReleases < 0 {
+		fatal("Number of kept releases can't be negative")
+	}
+	if !contains([]string{"hash", "keep-a-changelog", "git-auto"}, changelogFormat) {
+		fatalf("Unknown changelog format: %s\n", changelogFormat)
+	}
+	if _, ok := checksumHashes[checksumAlgo]; !ok {
+		if checksumAlgo == "blake3" {
+			fatal("BLAKE3 checksums need gop built with -tags blake3")
+		}
+		fatalf("Unsupported checksum algorithm: %s\n", checksumAlgo)
+	}
+	if !contains([]string{"legacy", "module"}, licenseNaming) {
+		fatalf("Unknown license naming: %s\n", licenseNaming)
+	}
+	if single {
+		if targets != "" {
+			fatal("Please use either -single or -targets")
+		}
+		targets = runtime.GOOS + "/" + runtime.GOARCH
+	}
+}
+
+// Change to the project root, see -dir, git still finds the repository above it
+func enterProjectDir() {
+	if projectDir != "" {
+		if err := os.Chdir(projectDir); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// Version, notes and checks shared by packaging and releasing
+func prepare() {
+	// Print tool versions for bug reports
+	if verbose {
+		toolVersions()
+	}
+
+	// Get project info
+	projectInfo(modFile)
+
+	// Check the toolchain against go.mod
+	switch minGo {
+	case "off":
+	case "warn", "fail":
+		checkGoVersion()
+	default:
+		fatalf("Unknown go version check mode: %s\n", minGo)
+	}
+
+	// Get version and changelog
+	switch versionSource {
+	case "changelog":
+		changes(logName, "")
+	case "git":
+		version = latestTag()
+		// Notes are optional when versioning with tags, git-auto needs no file
+		if _, err := os.Stat(logName); err == nil || changelogFormat == "git-auto" {
+			changes(logName, version)
+		}
+	default:
+		fatalf("Unknown version source: %s\n", versionSource)
+	}
+
+	// Output directory
+	outDir = distDir
+	if versionedDist {
+		outDir = filepath.Join(distDir, version)
+	}
+
+	// Project banner
+	if banner {
+		printBanner()
+	}
+
+	// Notes from commits instead of the changelog
+	if autoNotes {
+		changelog = commitNotes(version)
+	}
+
+	// Check version against git tag
+	switch checkTagMode {
+	case "off":
+	case "warn", "fail":
+		checkTag()
+	default:
+		fatalf("Unknown tag check mode: %s\n", checkTagMode)
+	}
+
+	// Check working tree before anything is built
+	if releaseFlag && !allowDirty {
+		checkClean()
+	}
+
+	// Vet and test before anything is built
+	if checkFlag && (packFlag || releaseFlag) {
+		check()
+	}
+}
+
+// Append the version and packaged assets to a Github Actions output file
+func writeGitHubOutput(path string) {
+	var assets []string
+	if packFlag {
+		files, err := ioutil.ReadDir(outDir)
+		if err != nil {
+			fatal(err)
+		}
+		for _, f := range files {
+			if !f.IsDir() {
+				assets = append(assets, filepath.ToSlash(filepath.Join(outDir, f.Name())))
+			}
+		}
+	}
+	var b strings.Builder
+	b.WriteString("version=" + version + "\n")
+	b.WriteString("asset-count=" + strconv.Itoa(len(assets)) + "\n")
+	// Multiline values are delimited
+	b.WriteString("assets<<GOP_ASSETS\n")
+	for _, a := range assets {
+		b.WriteString(a + "\n")
+	}
+	b.WriteString("GOP_ASSETS\n")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(b.String()); err != nil {
+		fatal(err)
+	}
+}
+
+func gopVersionString() string {
+	if Version != "" {
+		return Version
+	}
+	// Fallback to the module version when installed with go install
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+func projectInfo(s string) {
+	f, err := os.Open(s)
+	if err != nil {
+		abs, _ := filepath.Abs(s)
+		wd, _ := os.Getwd()
+		fatalf("Could not open %s from %s\nPlease generate mod file, use: go mod init <path>\n", abs, wd)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "module":
+			modulePath = fields[1]
+			a := strings.Split(fields[1], "/")
+			projectName = a[len(a)-1]
+		case "go":
+			goVersion = fields[1]
+		case "require":
+			hasDeps = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fatal(err)
+	}
+}
+
+// Get the changelog section of a version, the first section when the version is empty
+func changes(s string, want string) {
+	parser := newChangelogParser(changelogFormat)
+	var r io.Reader = strings.NewReader("")
+	if changelogFormat != "git-auto" {
+		f, err := os.Open(s)
+		if err != nil {
+			fatalf("Please add %s\n", logName)
+		}
+		defer f.Close()
+		r = f
+	}
+	v, c, err := parser.parse(r, want)
+	if err != nil {
+		fatal(err)
+	}
+	if v != "" {
+		version = v
+	}
+	changelog = c
+}
+
+// Changelog parser, see -changelog-format
+type changelogParser interface {
+	// Version and notes of the wanted section, the first section if want is empty
+	parse(r io.Reader, want string) (version, notes string, err error)
+}
+
+func newChangelogParser(format string) changelogParser {
+	switch format {
+	case "keep-a-changelog":
+		return keepAChangelogParser{}
+	case "git-auto":
+		return gitParser{}
+	}
+	return hashParser{}
+}
+
+// Sections start with # <version>
+type hashParser struct{}
+
+func (hashParser) parse(r io.Reader, want string) (version, changelog string, err error) {
+	var b strings.Builder
+	in := false
+	titled := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		t := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(t) > 2 && t[0:2] == "# " {
+			if in {
+				break
+			}
+			v := strings.TrimSpace(t[1:])
+			// A title before the first version, its intro isn't part of the notes
+			if !in && isChangelogTitle(v) {
+				titled = true
+				continue
+			}
+			if want == "" || v == want {
+				if titled {
+					b.Reset()
+				}
+				in = true
+				version = v
+			}
+		} else if in || want == "" {
+			b.WriteString(t)
+			b.WriteString("\n")
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	return version, strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// Sections start with ## [<version>] - <date>, the Unreleased section is skipped
+type keepAChangelogParser struct{}
+
+func (keepAChangelogParser) parse(r io.Reader, want string) (version, changelog string, err error) {
+	var b strings.Builder
+	in := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		t := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.HasPrefix(t, "## ") {
+			if in {
+				break
+			}
+			v := strings.TrimSpace(t[3:])
+			if i := strings.Index(v, " - "); i >= 0 {
+				v = v[:i]
+			}
+			v = strings.Trim(v, "[]")
+			if strings.EqualFold(v, "unreleased") {
+				continue
+			}
+			if want == "" || v == want {
+				in = true
+				version = v
+			}
+		} else if in {
+			b.WriteString(t)
+			b.WriteString("\n")
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	return version, strings.TrimSpace(b.String()), nil
+}
+
+// Version from the latest tag and notes from the commits since the previous one, the changelog is unused
+type gitParser struct{}
+
+func (gitParser) parse(r io.Reader, want string) (string, string, error) {
+	if want == "" {
+		want = latestTag()
+	}
+	return want, commitNotes(want), nil
+}
+
+func isChangelogTitle(s string) bool {
+	// Titles may contain spaces
+	for _, t := range strings.Split(changelogTitles, ",") {
+		if strings.EqualFold(s, strings.TrimSpace(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+func pack() {
+	atomic.StoreInt32(&packing, 1)
+	defer atomic.StoreInt32(&packing, 0)
+
+	// Make directories if !exist else truncate, binaries are kept when incremental
+	mkdirOrTruncate(outDir)
+	if incremental {
+		mkdirIfNotExist(binDir)
+	} else {
+		mkdirOrTruncate(binDir)
+	}
+
+	// Check tools before building
+	if linuxPackages {
+		requireTool("nfpm", "go install github.com/goreleaser/nfpm/v2/cmd/nfpm@latest")
+	}
+	if upx {
+		requireTool("upx", "https://github.com/upx/upx/releases")
+	}
+	if cosign {
+		requireTool("cosign", "go install github.com/sigstore/cosign/v2/cmd/cosign@latest")
+	}
+	if goxPath != "" {
+		checkGoxPath()
+	}
+
+	// Run pre-build hook
+	if preBuild != "" {
+		runHook("pre-build", preBuild, nil)
+	}
+
+	// Run gox, skipped when incremental and nothing changed
+	start := time.Now()
+	built := true
+	var hash string
+	if incremental {
+		hash = buildHash()
+		built = !upToDate(hash)
+	}
+	if built {
+		runGox(binDir)
+	} else {
+		printf("\nBinaries up to date\n")
+	}
+	vprintf("Build took %v\n", time.Since(start).Round(time.Millisecond))
+
+	// Get binaries
+	binaries := readBinaries(binDir)
+
+	// Check disk space
+	checkSpace(binaries)
+
+	// Compress binaries, kept ones already are
+	if upx && built {
+		compress(binaries)
+	}
+
+	// Binaries are kept once built and compressed
+	if incremental && built {
+		writeBuildHash(hash)
+	}
+
+	// Files to package, example: files{"<to path>": "<from path>", ... }
+	files := make(map[string]string)
+	// Aggregated third party notices
+	var notices string
+
+	// Extra directories, every file is included like -include
+	for _, inc := range includeDirs {
+		if info, err := os.Stat(inc.From); err != nil {
+			fatal(err)
+		} else if !info.IsDir() {
+			fatalf("%s is not a directory\n", inc.From)
+		}
+		funcWalk(inc.From, func(root string, path string, info fs.FileInfo) {
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				fatal(err)
+			}
+			includes = append(includes, include{From: path, To: filepath.Join(inc.To, rel), Target: inc.Target})
+		})
+	}
+
+	// Extra files, targeted ones are added per binary
+	for _, inc := range includes {
+		if _, err := os.Stat(inc.From); err != nil {
+			fatal(err)
+		}
+		if inc.Target == "" {
+			files[filepath.ToSlash(inc.To)] = inc.From
+		}
+	}
+
+	if !noLicense {
+		// Get vendors, there's nothing to vendor without requirements
+		if hasDeps {
+			vendor()
+			n := len(files)
+			collect(files, vendorDir)
+			if strictLicense && len(files) == n {
+				fatalf("No dependency licenses found for %s\n", projectName)
+			}
+			if verbose {
+				licenseSummary(files, vendorDir)
+			}
+		} else {
+			vprintf("No dependencies, skipping vendor licenses\n")
+		}
+		// Aggregate notices
+		if aggregateNotices {
+			notices = aggregate(files)
+		}
+		// Collect project license
+		lic := collectProjectLicense()
+		if lic != "" {
+			files[filepath.Join(licenseDir, projectLicenseName(lic))] = lic
+		} else if strictLicense {
+			fatalf("No license found for %s\n", projectName)
+		} else {
+			eprintf("\n\u2757 Packaging %s without license\n", projectName)
+			emit(event{Event: "warning", Message: "Packaging " + projectName + " without license"})
+		}
+	}
+
+	// Detect project license type
+	licenseID = detectLicense(collectProjectLicense())
+
+	// Readme
+	readme := readme(projectName)
+	if checkLinks && !noReadme {
+		checkLink(readmeURL())
+	}
+
+	// Archive name template
+	var tmpl *template.Template
+	if archiveTemplate != "" {
+		var err error
+		tmpl, err = template.New("archive").Parse(archiveTemplate)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	// Top-level directory template
+	var rootTmpl *template.Template
+	if rootTemplate != "" {
+		var err error
+		rootTmpl, err = template.New("root").Parse(rootTemplate)
+		if err != nil {
+			fatal(err)
+		}
+	}
+
+	// Short commit for snapshot names, either template may use it
+	if strings.Contains(archiveTemplate, ".Commit") || strings.Contains(rootTemplate, ".Commit") {
+		out, err := cmdOutput(exec.Command("git", "rev-parse", "--short", "HEAD"))
+		if err != nil {
+			fatal(err)
+		}
+		commit = strings.TrimSpace(string(out))
+	}
+
+	// Shared entries, sorted and only read while packaging
+	var shared []ArchiveEntry
+	for to, from := range files {
+		shared = append(shared, ArchiveEntry{To: filepath.ToSlash(to), From: from})
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		return shared[i].To < shared[j].To
+	})
+
+	// Archive names, a template without .OS or .Arch would write every target to the same archive
+	names := make([]string, len(binaries))
+	seen := make(map[string]string)
+	for i, bin := range binaries {
+		names[i] = archiveName(tmpl, parseBinary(bin.Name()))
+		if other, ok := seen[names[i]]; ok {
+			fatalf("%s and %s would both be packaged as %s, please add .OS and .Arch to -archive-template\n", other, bin.Name(), names[i])
+		}
+		seen[names[i]] = bin.Name()
+	}
+
+	// Package files
+	printf("\nPackaging:\n\n")
+	var wg sync.WaitGroup
+	assets := make([]asset, len(binaries))
+	errs := make([]error, len(binaries))
+	for i, bin := range binaries {
+		wg.Add(1)
+		go func(i int, bin binary) {
+			defer wg.Done()
+			// Failures end the run once every package is done
+			defer catch(&errs[i])
+			ext := bin.Ext
+			name := names[i]
+			assets[i] = asset{Name: name, OS: bin.OS, Arch: bin.Arch}
+
+			// Create unique archive for each binary
+			f, err := os.Create(filepath.Join(outDir, name))
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+
+			// Gzip the binary alone
+			if format == "gz" {
+				err = gzipFile(f, filepath.Join(binDir, bin.Name))
+				if err != nil {
+					fatal(err)
+				}
+				printf("\U0001F4E6 %s\n", name)
+				emit(event{Event: "pack", Asset: name, Status: "packaged"})
+				return
+			}
+			// Top-level directory, the archive name unless templated
+			var root string
+			if nested {
+				root = archiveBase(tmpl, bin)
+				if rootTmpl != nil {
+					root = archiveBase(rootTmpl, bin)
+				}
+			}
+			w := newArchive(f, archiveExt(bin))
+			if archiveChecksums {
+				w = &summedArchive{archive: w, root: root}
+			}
+			defer w.Close()
+
+			// Readme
+			var entries []ArchiveEntry
+			if !noReadme {
+				entries = append(entries, ArchiveEntry{To: readmeName, Content: readme})
+			}
+
+			// Notices
+			if notices != "" {
+				entries = append(entries, ArchiveEntry{To: path.Join(filepath.ToSlash(licenseDir), packNoticesName), Content: notices})
+			}
+
+			// Binary, executable when extracted on unix
+			entries = append(entries, ArchiveEntry{To: projectName + ext, From: filepath.Join(binDir, bin.Name), Mode: 0755})
+
+			// Files, copied into this package's entries
+			entries = append(entries, shared...)
+			for _, inc := range includes {
+				if inc.Target == "" || !inc.matches(bin) {
+					continue
+				}
+				entries = append(entries, ArchiveEntry{To: filepath.ToSlash(inc.To), From: inc.From})
+			}
+
+			// Entries changed by the caller of Pack
+			if archiveHook != nil {
+				entries = archiveHook(entries)
+			}
+
+			// Write entries to archive
+			if root != "" {
+				for i := range entries {
+					entries[i].To = path.Join(root, filepath.ToSlash(entries[i].To))
+				}
+			}
+			printf("\U0001F4E6 %s\n", name)
+			for _, e := range entries {
+				if err := e.write(w); err != nil {
+					fatal(err)
+				}
+			}
+			emit(event{Event: "pack", Asset: name, Status: "packaged"})
+		}(i, parseBinary(bin.Name()))
+	}
+	wg.Wait()
+	failFirst(errs)
+
+	// Contents of one package as a check
+	if verbose && len(assets) > 0 {
+		describeArchive(filepath.Join(outDir, assets[0].Name))
+	}
+
+	// Linux packages
+	if linuxPackages {
+		nfpm(binaries)
+	}
+
+	// Run post-package hook
+	if postPackage != "" {
+		runHook("post-package", postPackage, []string{
+			"GOP_DIST_DIR=" + outDir,
+			"GOP_VERSION=" + version,
+		})
+	}
+
+	// Describe assets for manifests
+	if latest || homebrew || scoop {
+		assets = describeAssets(assets)
+	}
+
+	// Latest release manifest
+	if latest {
+		writeManifest(filepath.Join(outDir, latestName), assets)
+	}
+
+	// Homebrew formula
+	if homebrew {
+		writeFormula(filepath.Join(outDir, projectName+".rb"), assets)
+	}
+
+	// Scoop manifest
+	if scoop {
+		writeScoop(filepath.Join(outDir, projectName+".json"), assets)
+	}
+
+	// Software bill of materials
+	if sbom {
+		writeSBOM()
+	}
+
+	// Sign archives
+	if cosign {
+		signBlobs(assets)
+	}
+
+	// Single archive replacing the target archives, checksummed like the rest
+	if bundleOnly {
+		writeBundle(outDir, assets)
+	}
+
+	// Checksums
+	if checksums {
+		writeChecksums(outDir)
+	}
+
+	// Single archive of every asset
+	if bundle && !bundleOnly {
+		writeBundle(outDir, nil)
+	}
+
+	// Remove binaries
+	if cleanBin {
+		if err := os.RemoveAll(binDir); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+var nfpmTemplate = template.Must(template.New("nfpm").Parse(`name: "{{.Name}}"
+arch: "{{.Arch}}"
+platform: "linux"
+version: "{{.Version}}"
+maintainer: "{{.Maintainer}}"
+description: "{{.Name}}"
+homepage: "{{.Homepage}}"
+contents:
+  - src: "{{.Src}}"
+    dst: "/usr/bin/{{.Name}}"
+    file_info:
+      mode: 0755
+`))
+
+// Build deb and rpm packages for every linux binary
+func nfpm(binaries []fs.FileInfo) {
+	for _, bin := range binaries {
+		b := parseBinary(bin.Name())
+		if b.OS != "linux" {
+			continue
+		}
+		// Write config to temporary file
+		tmp := tempFile("nfpm*.yaml", false)
+		err := nfpmTemplate.Execute(tmp, map[string]string{
+			"Name":       projectName,
+			"Arch":       b.Arch,
+			"Version":    strings.TrimPrefix(version, "v"),
+			"Maintainer": moduleOwner(),
+			"Homepage":   projectURL(),
+			"Src":        filepath.Join(binDir, b.Name),
+		})
+		tmp.Close()
+		if err != nil {
+			os.Remove(tmp.Name())
+			fatal(err)
+		}
+		for _, packager := range []string{"deb", "rpm"} {
+			cmd := exec.Command("nfpm", "package", "--config", tmp.Name(), "--packager", packager, "--target", outDir)
+			if err := runCmd(cmd); err != nil {
+				os.Remove(tmp.Name())
+				fatalf("Could not build %s package for %s: %v\n", packager, b.Name, err)
+			}
+			printf("\U0001F4E6 %s (%s)\n", b.Name, packager)
+			emit(event{Event: "package", Asset: b.Name, Status: packager})
+		}
+		os.Remove(tmp.Name())
+	}
+}
+
+// Module owner, example: github.com/christianraza/gop -> christianraza
+func moduleOwner() string {
+	a := strings.Split(modulePath, "/")
+	if len(a) < 3 {
+		return projectName
+	}
+	return a[1]
+}
+
+// Add download urls and checksums to assets in a deterministic order
+func describeAssets(assets []asset) []asset {
+	var described []asset
+	for _, a := range assets {
+		sum, err := checksum(filepath.Join(outDir, a.Name))
+		if err != nil {
+			fatal(err)
+		}
+		if checksumAlgo != "sha256" {
+			a.Checksum, err = fileChecksum(filepath.Join(outDir, a.Name), checksumAlgo)
+			if err != nil {
+				fatal(err)
+			}
+		}
+		a.URL = releaseRepoURL() + "/releases/download/" + version + "/" + a.Name
+		a.SHA256 = sum
+		described = append(described, a)
+	}
+	sort.Slice(described, func(i, j int) bool {
+		return described[i].Name < described[j].Name
+	})
+	return described
+}
+
+// Version of the go command used to build
+func toolchainVersion() string {
+	cmd := exec.Command("go", "env", "GOVERSION")
+	cmd.Stderr = ioutil.Discard
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func writeManifest(path string, assets []asset) {
+	m := manifest{
+		Name:    projectName,
+		Version: version,
+		License: licenseID,
+		Build: buildInfo{
+			Go:        goVersion,
+			Toolchain: toolchainVersion(),
+			Gop:       gopVersionString(),
+			Runtime:   runtime.Version(),
+		},
+		Assets: assets,
+	}
+	if checksumAlgo != "sha256" {
+		m.ChecksumAlgo = checksumAlgo
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		fatal(err)
+	}
+	err = ioutil.WriteFile(path, append(b, '\n'), 0644)
+	if err != nil {
+		fatal(err)
+	}
+	printf("\U0001F4DD %s\n", filepath.Base(path))
+	emit(event{Event: "manifest", Asset: filepath.Base(path), Status: "written"})
+}
+
+// Homebrew formula template data
+type formulaData struct {
+	Class    string
+	Name     string
+	Homepage string
+	Version  string
+	Bin      string
+	Darwin   map[string]*asset
+	Linux    map[string]*asset
+}
+
+var formulaTemplate = template.Must(template.New("formula").Parse(`class {{.Class}} < Formula
+  desc "{{.Name}}"
+  homepage "{{.Homepage}}"
+  version "{{.Version}}"
+{{- if .Darwin}}
+
+  on_macos do
+{{- with index .Darwin "arm"}}
+    if Hardware::CPU.arm?
+      url "{{.URL}}"
+      sha256 "{{.SHA256}}"
+    end
+{{- end}}
+{{- with index .Darwin "intel"}}
+    if Hardware::CPU.intel?
+      url "{{.URL}}"
+      sha256 "{{.SHA256}}"
+    end
+{{- end}}
+  end
+{{- end}}
+{{- if .Linux}}
+
+  on_linux do
+{{- with index .Linux "arm"}}
+    if Hardware::CPU.arm?
+      url "{{.URL}}"
+      sha256 "{{.SHA256}}"
+    end
+{{- end}}
+{{- with index .Linux "intel"}}
+    if Hardware::CPU.intel?
+      url "{{.URL}}"
+      sha256 "{{.SHA256}}"
+    end
+{{- end}}
+  end
+{{- end}}
+
+  def install
+    bin.install "{{.Bin}}"
+  end
+end
+`))
+
+func writeFormula(path string, assets []asset) {
+	data := formulaData{
+		Class:    formulaClass(projectName),
+		Name:     projectName,
+		Homepage: projectURL(),
+		Version:  strings.TrimPrefix(version, "v"),
+		Bin:      projectName,
+		Darwin:   make(map[string]*asset),
+		Linux:    make(map[string]*asset),
+	}
+	// Homebrew only distinguishes arm and intel cpus
+	cpus := map[string]string{"arm64": "arm", "amd64": "intel"}
+	for i, a := range assets {
+		cpu, ok := cpus[a.Arch]
+		if !ok {
+			continue
+		}
+		switch a.OS {
+		case "darwin":
+			data.Darwin[cpu] = &assets[i]
+		case "linux":
+			data.Linux[cpu] = &assets[i]
+		}
+	}
+	if len(data.Darwin) == 0 && len(data.Linux) == 0 {
+		eprintf("\n\u2757 No darwin or linux packages for Homebrew formula\n")
+		emit(event{Event: "warning", Message: "No darwin or linux packages for Homebrew formula"})
+		return
+	}
+	var b strings.Builder
+	if err := formulaTemplate.Execute(&b, data); err != nil {
+		fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		fatal(err)
+	}
+	printf("\U0001F37A %s\n", filepath.Base(path))
+	emit(event{Event: "formula", Asset: filepath.Base(path), Status: "written"})
+}
+
+// Homebrew class name, example: my-tool -> MyTool
+func formulaClass(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Scoop manifest
+type scoopManifest struct {
+	Version  string `json:"version"`
+	Homepage string `json:"homepage"`
+	URL      string `json:"url"`
+	Hash     string `json:"hash"`
+	Bin      string `json:"bin"`
+}
+
+func writeScoop(path string, assets []asset) {
+	for _, a := range assets {
+		if a.OS != "windows" || a.Arch != "amd64" {
+			continue
+		}
+		m := scoopManifest{
+			Version:  strings.TrimPrefix(version, "v"),
+			Homepage: projectURL(),
+			URL:      a.URL,
+			Hash:     a.SHA256,
+			Bin:      projectName + ".exe",
+		}
+		b, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			fatal(err)
+		}
+		err = ioutil.WriteFile(path, append(b, '\n'), 0644)
+		if err != nil {
+			fatal(err)
+		}
+		printf("\U0001F944 %s\n", filepath.Base(path))
+		emit(event{Event: "scoop", Asset: filepath.Base(path), Status: "written"})
+		return
+	}
+	eprintf("\n\u2757 No windows/amd64 package for Scoop manifest\n")
+	emit(event{Event: "warning", Message: "No windows/amd64 package for Scoop manifest"})
+}
+
+// CycloneDX software bill of materials, only what gop fills in
+type cycloneDX struct {
+	BOMFormat   string `json:"bomFormat"`
+	SpecVersion string `json:"specVersion"`
+	Version     int    `json:"version"`
+	Metadata    struct {
+		Component cdxComponent `json:"component"`
+	} `json:"metadata"`
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	PURL    string `json:"purl"`
+}
+
+// SPDX software bill of materials, only what gop fills in
+type spdx struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages []spdxPackage `json:"packages"`
+}
+
+type spdxPackage struct {
+	Name             string `json:"name"`
+	SPDXID           string `json:"SPDXID"`
+	VersionInfo      string `json:"versionInfo"`
+	DownloadLocation string `json:"downloadLocation"`
+}
+
+// Write a software bill of materials with syft, or from the module list when syft is missing
+func writeSBOM() {
+	var name, syftFormat string
+	switch sbomFormat {
+	case "cyclonedx":
+		name, syftFormat = projectName+".cdx.json", "cyclonedx-json"
+	case "spdx":
+		name, syftFormat = projectName+".spdx.json", "spdx-json"
+	default:
+		fatalf("Unknown software bill of materials format: %s\n", sbomFormat)
+	}
+	path := filepath.Join(outDir, name)
+	if _, err := exec.LookPath("syft"); err == nil {
+		err := runCmd(exec.Command("syft", "dir:.", "-o", syftFormat+"="+path))
+		if err != nil {
+			fatalf("Could not generate software bill of materials: %v\n", err)
+		}
+	} else {
+		vprintf("syft not found, generating software bill of materials from modules\n")
+		writeModuleSBOM(path)
+	}
+	printf("\U0001F4CB %s\n", name)
+	emit(event{Event: "sbom", Asset: name, Status: "written"})
+}
+
+func writeModuleSBOM(path string) {
+	out, err := cmdOutput(exec.Command("go", "list", "-m", "all"))
+	if err != nil {
+		fatal(err)
+	}
+	// First module is the main module
+	var modules [][2]string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		modules = append(modules, [2]string{fields[0], fields[1]})
+	}
+
+	var doc interface{}
+	if sbomFormat == "spdx" {
+		s := spdx{
+			SPDXVersion:       "SPDX-2.3",
+			DataLicense:       "CC0-1.0",
+			SPDXID:            "SPDXRef-DOCUMENT",
+			Name:              projectName + "-" + version,
+			DocumentNamespace: projectURL() + "/spdx/" + version,
+		}
+		s.CreationInfo.Created = time.Now().UTC().Format(time.RFC3339)
+		s.CreationInfo.Creators = []string{"Tool: gop-" + gopVersionString()}
+		s.Packages = append(s.Packages, spdxPackage{projectName, "SPDXRef-Package-0", version, "NOASSERTION"})
+		for i, m := range modules {
+			s.Packages = append(s.Packages, spdxPackage{m[0], fmt.Sprintf("SPDXRef-Package-%d", i+1), m[1], "NOASSERTION"})
+		}
+		doc = s
+	} else {
+		c := cycloneDX{BOMFormat: "CycloneDX", SpecVersion: "1.4", Version: 1, Components: []cdxComponent{}}
+		c.Metadata.Component = cdxComponent{"application", modulePath, version, "pkg:golang/" + modulePath + "@" + version}
+		for _, m := range modules {
+			c.Components = append(c.Components, cdxComponent{"library", m[0], m[1], "pkg:golang/" + m[0] + "@" + m[1]})
+		}
+		doc = c
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		fatal(err)
+	}
+	err = ioutil.WriteFile(path, append(b, '\n'), 0644)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// Sign archives with cosign, writing <archive>.sig and <archive>.pem
+func signBlobs(assets []asset) {
+	printf("\nSigning:\n\n")
+	for _, a := range assets {
+		path := filepath.Join(outDir, a.Name)
+		cmd := exec.Command("cosign", "sign-blob", "--yes",
+			"--output-signature", path+".sig",
+			"--output-certificate", path+".pem",
+			path)
+		if err := runCmd(cmd); err != nil {
+			fatalf("Could not sign %s: %v\n", a.Name, err)
+		}
+		printf("\u270D %s\n", a.Name)
+		emit(event{Event: "sign", Asset: a.Name, Status: "signed"})
+	}
+}
+
+func writeChecksums(dir string) {
+	assets, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fatal(err)
+	}
+	var b strings.Builder
+	b.WriteString("# " + checksumAlgo + "\n")
+	for _, a := range assets {
+		if a.IsDir() || a.Name() == checksumsName {
+			continue
+		}
+		sum, err := fileChecksum(filepath.Join(dir, a.Name()), checksumAlgo)
+		if err != nil {
+			fatal(err)
+		}
+		b.WriteString(sum + "  " + a.Name() + "\n")
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, checksumsName), []byte(b.String()), 0644)
+	if err != nil {
+		fatal(err)
+	}
+	printf("\U0001F511 %s\n", checksumsName)
+	emit(event{Event: "checksums", Asset: checksumsName, Status: "written"})
+}
+
+// SHA256 of a file, Homebrew and Scoop only take sha256
+func checksum(path string) (string, error) {
+	return fileChecksum(path, "sha256")
+}
+
+// Checksum algorithms by name, blake3 is added when built with -tags blake3
+var checksumHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+func fileChecksum(path string, algo string) (string, error) {
+	newHash, ok := checksumHashes[algo]
+	if !ok {
+		return "", fmt.Errorf("unsupported checksum algorithm: %s", algo)
+	}
+	h := newHash()
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func verify(dir string) {
+	f, err := os.Open(filepath.Join(dir, checksumsName))
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+
+	printf("\nVerifying:\n\n")
+	failed := false
+	algo := "sha256"
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// The header names the algorithm
+		if strings.HasPrefix(line, "#") {
+			algo = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		// Names may be prefixed with '*' when written in binary mode
+		want, name := fields[0], strings.TrimPrefix(strings.TrimSpace(fields[1]), "*")
+		sum, err := fileChecksum(filepath.Join(dir, name), algo)
+		switch {
+		case os.IsNotExist(err):
+			failed = true
+			eprintf("\u2757 %s missing\n", name)
+			emit(event{Event: "verify", Asset: name, Status: "missing"})
+		case err != nil:
+			fatal(err)
+		case sum != want:
+			failed = true
+			eprintf("\u2757 %s checksum mismatch\n", name)
+			emit(event{Event: "verify", Asset: name, Status: "mismatch"})
+		default:
+			printf("\u2705 %s\n", name)
+			emit(event{Event: "verify", Asset: name, Status: "ok"})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fatal(err)
+	}
+	if failed {
+		stop(1, "Checksums don't match")
+	}
+}
+
+func parseBinary(name string) binary {
+	b := binary{Name: name, Ext: binExt(name)}
+	b.Base = strings.TrimSuffix(name, b.Ext)
+	// Neither os nor arch contain dashes so parse from the end
+	a := strings.Split(b.Base, "-")
+	if len(a) >= 3 {
+		b.OS = a[len(a)-2]
+		b.Arch = a[len(a)-1]
+	}
+	return b
+}
+
+func archiveBase(tmpl *template.Template, bin binary) string {
+	if tmpl == nil {
+		return bin.Base
+	}
+	var b strings.Builder
+	err := tmpl.Execute(&b, archiveData{
+		Name:    projectName,
+		Version: version,
+		OS:      bin.OS,
+		Arch:    bin.Arch,
+		License: licenseID,
+		Commit:  commit,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	return b.String()
+}
+
+// Archive file name of a binary
+func archiveName(tmpl *template.Template, bin binary) string {
+	if format == "gz" {
+		// The binary keeps its extension
+		return archiveBase(tmpl, bin) + bin.Ext + archiveExt(bin)
+	}
+	return archiveBase(tmpl, bin) + archiveExt(bin)
+}
+
+// Executable extension, only windows binaries have one and names may contain dots
+func binExt(name string) string {
+	if strings.HasSuffix(name, ".exe") {
+		return ".exe"
+	}
+	return ""
+}
+
+// Abort if the distribution directory's filesystem can't fit the estimated archives
+func checkSpace(binaries []fs.FileInfo) {
+	var size int64
+	for _, bin := range binaries {
+		size += bin.Size()
+	}
+	required := uint64(float64(size) * archiveFactor)
+	free, err := freeSpace(outDir)
+	if err != nil {
+		vprintf("Could not check disk space: %v\n", err)
+		return
+	}
+	vprintf("Disk space: %d bytes required, %d bytes free\n", required, free)
+	if free < required {
+		fatalf("Not enough disk space to package, %d bytes required but %d bytes free\n", required, free)
+	}
+}
+
+// Compress binaries in place with UPX, targets UPX can't handle are skipped
+func compress(binaries []fs.FileInfo) {
+	printf("\nCompressing:\n\n")
+	for _, bin := range binaries {
+		b := parseBinary(bin.Name())
+		if b.OS == "darwin" {
+			eprintf("\u2757 Skipping %s, UPX does not support darwin\n", b.Name)
+			emit(event{Event: "compress", Asset: b.Name, Status: "skipped"})
+			continue
+		}
+		path := filepath.Join(binDir, b.Name)
+		cmd := exec.Command("upx", "-q", path)
+		cmd.Stdout = ioutil.Discard
+		if verbose {
+			cmd.Stdout = stdout()
+		}
+		cmd.Stderr = stdout()
+		if err := runTimeout(cmd); err != nil {
+			eprintf("\u2757 Skipping %s, UPX failed: %v\n", b.Name, err)
+			emit(event{Event: "compress", Asset: b.Name, Status: "skipped"})
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			fatal(err)
+		}
+		printf("\U0001F5DC %s\n", b.Name)
+		vprintf("   %d -> %d bytes\n", bin.Size(), info.Size())
+		emit(event{Event: "compress", Asset: b.Name, Status: "compressed"})
+	}
+}
+
+// Vendor dependencies, marking the vendor directory when gop created it
+func vendor() {
+	marker := filepath.Join(vendorDir, vendorMarker)
+	_, err := os.Stat(vendorDir)
+	_, markErr := os.Stat(marker)
+	created := os.IsNotExist(err) || markErr == nil
+	// Vendoring recreates the directory
+	err = runCmd(exec.Command("go", "mod", "vendor"))
+	if err != nil {
+		fatal(err)
+	}
+	if _, err := os.Stat(vendorDir); created && err == nil {
+		err = ioutil.WriteFile(marker, nil, 0644)
+		if err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// Remove generated artifacts, a vendor directory is only removed if gop created it
+func clean() {
+	paths := []string{distDir, binDir}
+	if _, err := os.Stat(filepath.Join(vendorDir, vendorMarker)); err == nil {
+		paths = append(paths, vendorDir)
+	}
+	paths = append(paths, tempFiles()...)
+	for _, p := range paths {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			fatal(err)
+		}
+		printf("\U0001F9F9 %s\n", p)
+		emit(event{Event: "clean", Asset: p, Status: "removed"})
+	}
+}
+
+// Leftover temporary files of release notes and nfpm configs
+func tempFiles() []string {
+	var paths []string
+	for _, pattern := range []string{"temp*.md", "nfpm*.yaml"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			fatal(err)
+		}
+		for _, m := range matches {
+			if isTempName(m, pattern) {
+				paths = append(paths, m)
+			}
+		}
+	}
+	return paths
+}
+
+// Whether a name is one ioutil.TempFile gives the pattern, the random part is digits only,
+// so files such as nfpm.yaml or template.md aren't matched
+func isTempName(name string, pattern string) bool {
+	i := strings.Index(pattern, "*")
+	prefix, suffix := pattern[:i], pattern[i+1:]
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) || len(name) <= len(prefix)+len(suffix) {
+		return false
+	}
+	for _, r := range name[len(prefix) : len(name)-len(suffix)] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Temporary files created by this run, removed on interrupt
+var tempMu sync.Mutex
+var createdTemp []string
+
+// Create a temporary file in the project root, kept files aren't removed on interrupt
+func tempFile(pattern string, keep bool) *os.File {
+	f, err := ioutil.TempFile(".", pattern)
+	if err != nil {
+		fatal(err)
+	}
+	if !keep {
+		tempMu.Lock()
+		createdTemp = append(createdTemp, f.Name())
+		tempMu.Unlock()
+	}
+	return f
+}
+
+// Remove temporary files created by this run, and incomplete packages while packaging, on SIGINT or SIGTERM
+func handleSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		eprintf("\n\u2757 %v, cleaning up\n", sig)
+		tempMu.Lock()
+		for _, p := range createdTemp {
+			os.Remove(p)
+		}
+		tempMu.Unlock()
+		if atomic.LoadInt32(&packing) == 1 {
+			os.RemoveAll(outDir)
+		}
+		emit(event{Event: "interrupt", Message: sig.String()})
+		os.Exit(130)
+	}()
+}
+
+func collectProjectLicense() string {
+	files, err := ioutil.ReadDir(".")
+	if err != nil {
+		fatal(err)
+	}
+	for _, file := range files {
+		if isLicense(file.Name()) {
+			return file.Name()
+		}
+	}
+	return ""
+}
+
+// SPDX identifiers by key phrases, more specific phrases first, titles are matched with their version
+// since licenses mention each other
+var licensePhrases = []struct {
+	id      string
+	phrases []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license version 3"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license version 3"}},
+	{"GPL-2.0", []string{"gnu general public license version 2"}},
+	{"Apache-2.0", []string{"apache license version 2.0"}},
+	{"MPL-2.0", []string{"mozilla public license version 2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software"}},
+	{"Unlicense", []string{"this is free and unencumbered software"}},
+}
+
+// Guess the SPDX identifier of a license file, UNKNOWN when undetected
+func detectLicense(path string) string {
+	if path == "" {
+		return "UNKNOWN"
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "UNKNOWN"
+	}
+	// Normalize case and line wrapping
+	text := strings.ToLower(strings.Join(strings.Fields(string(content)), " "))
+	for _, l := range licensePhrases {
+		matched := true
+		for _, p := range l.phrases {
+			if !strings.Contains(text, p) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return l.id
+		}
+	}
+	return "UNKNOWN"
+}
+
+func projectLicenseName(lic string) string {
+	switch licenseName {
+	case "project":
+		return projectName + "-" + strings.ToLower(lic)
+	case "original":
+		return lic
+	}
+	// Keep the extension if the source had one
+	if filepath.Ext(licenseName) == "" {
+		return licenseName + filepath.Ext(lic)
+	}
+	return licenseName
+}
+
+// Archive format by target, windows gets zip and everything else tar.gz in auto mode
+func archiveExt(bin binary) string {
+	switch format {
+	case "auto":
+		if bin.OS == "windows" {
+			return ".zip"
+		}
+		return ".tar.gz"
+	case "tar.gz":
+		return ".tar.gz"
+	case "gz":
+		return ".gz"
+	}
+	return ".zip"
+}
+
+// Gzip a single file keeping its name in the header
+func gzipFile(w io.Writer, from string) error {
+	f, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(w)
+	gz.Name = filepath.Base(from)
+	if _, err := io.Copy(gz, f); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ArchiveEntry is a file of a zip or tar.gz package, written from a file or from content
+type ArchiveEntry struct {
+	// Slash separated path in the archive, relative to the top-level directory of -nested
+	To string
+	// File to package, Content is packaged when empty
+	From    string
+	Content string
+	// Mode of From, the archive default when zero
+	Mode fs.FileMode
+}
+
+func (e ArchiveEntry) write(a archive) error {
+	if e.From == "" {
+		return addString(a, e.To, e.Content)
+	}
+	return addFile(a, e.To, e.From, e.Mode)
+}
+
+// Package archive
+type archive interface {
+	// Create an entry, tar needs the size up front
+	create(name string, size int64, mode fs.FileMode) (io.Writer, error)
+	Close() error
+}
+
+func newArchive(w io.Writer, ext string) archive {
+	if ext == ".tar.gz" {
+		gz := gzip.NewWriter(w)
+		return &tarArchive{gz: gz, w: tar.NewWriter(gz)}
+	}
+	return &zipArchive{w: zip.NewWriter(w)}
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+// Create a zip entry, stored instead of deflated when already compressed
+func (a *zipArchive) create(name string, size int64, mode fs.FileMode) (io.Writer, error) {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	if contains(splitList(storeExts), strings.ToLower(path.Ext(name))) {
+		header.Method = zip.Store
+	}
+	if mode != 0 {
+		header.SetMode(mode)
+	}
+	return a.w.CreateHeader(header)
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	w  *tar.Writer
+}
+
+func (a *tarArchive) create(name string, size int64, mode fs.FileMode) (io.Writer, error) {
+	if mode == 0 {
+		mode = 0644
+	}
+	err := a.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     int64(mode),
+		ModTime:  time.Unix(0, 0),
+	})
+	return a.w, err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.w.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// Zip every asset in dir into one archive, removing the given archives after, see -bundle-only
+func writeBundle(dir string, remove []asset) {
+	assets, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fatal(err)
+	}
+	name := projectName + "-" + version + "-" + bundleName
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+	w := newArchive(f, ".zip")
+	for _, a := range assets {
+		if a.IsDir() {
+			continue
+		}
+		err = addFile(w, a.Name(), filepath.Join(dir, a.Name()), 0)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		fatal(err)
+	}
+	for _, a := range remove {
+		if err := os.Remove(filepath.Join(dir, a.Name)); err != nil {
+			fatal(err)
+		}
+	}
+	printf("\U0001F4E6 %s\n", name)
+	emit(event{Event: "bundle", Asset: name, Status: "packaged"})
+}
+
+// Print the entries of a zip or tar.gz package with their sizes
+func describeArchive(path string) {
+	type entry struct {
+		name string
+		size int64
+	}
+	var entries []entry
+	switch {
+	case strings.HasSuffix(path, ".zip"):
+		r, err := zip.OpenReader(path)
+		if err != nil {
+			fatal(err)
+		}
+		defer r.Close()
+		for _, f := range r.File {
+			entries = append(entries, entry{f.Name, int64(f.UncompressedSize64)})
+		}
+	case strings.HasSuffix(path, ".tar.gz"):
+		f, err := os.Open(path)
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			fatal(err)
+		}
+		r := tar.NewReader(gz)
+		for {
+			h, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				fatal(err)
+			}
+			entries = append(entries, entry{h.Name, h.Size})
+		}
+	default:
+		return
+	}
+	vprintf("\n%s:\n\n", filepath.Base(path))
+	for _, e := range entries {
+		vprintf("%10d  %s\n", e.size, e.name)
+	}
+}
+
+// Archive writing the SHA256 of every entry to SHA256SUMS when closed, see -archive-checksums
+type summedArchive struct {
+	archive
+	// Top-level directory with -nested, sums are relative to it
+	root   string
+	names  []string
+	hashes []hash.Hash
+}
+
+func (a *summedArchive) create(name string, size int64, mode fs.FileMode) (io.Writer, error) {
+	w, err := a.archive.create(name, size, mode)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	if a.root != "" {
+		name = strings.TrimPrefix(name, a.root+"/")
+	}
+	a.names = append(a.names, name)
+	a.hashes = append(a.hashes, h)
+	return io.MultiWriter(w, h), nil
+}
+
+func (a *summedArchive) Close() error {
+	var b strings.Builder
+	for i, name := range a.names {
+		b.WriteString(hex.EncodeToString(a.hashes[i].Sum(nil)) + "  " + name + "\n")
+	}
+	if err := addString(a.archive, path.Join(a.root, packSumsName), b.String()); err != nil {
+		return err
+	}
+	return a.archive.Close()
+}
+
+func addString(a archive, name string, content string) error {
+	to, err := a.create(name, int64(len(content)), 0)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(to, strings.NewReader(content))
+	return err
+}
+
+func addFile(a archive, name string, from string, mode fs.FileMode) error {
+	f, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	to, err := a.create(name, info.Size(), mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(to, f)
+	return err
+}
+
+func isLicense(fname string) bool {
+	name := strings.ToLower(strings.TrimSuffix(fname, filepath.Ext(fname)))
+	return (name == "license" || name == "copying" || name == "notice")
+}
+
+func mkdirOrTruncate(name string) {
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		os.RemoveAll(name)
+	}
+	err := os.MkdirAll(name, 0755)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func mkdirIfNotExist(name string) {
+	err := os.MkdirAll(name, 0755)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// Built binaries, hidden files such as the build hash are skipped
+func readBinaries(dir string) []fs.FileInfo {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		fatal(err)
+	}
+	var binaries []fs.FileInfo
+	for _, f := range files {
+		if !f.IsDir() && !strings.HasPrefix(f.Name(), ".") {
+			binaries = append(binaries, f)
+		}
+	}
+	return binaries
+}
+
+// Hash of the sources and settings that affect the build
+func buildHash() string {
+	h := sha256.New()
+	fmt.Fprintln(h, targets, excludeTargets, goxFlags, buildEnv, windowsGUI, upx)
+	// Every file may be built in, embedded files and cgo sources too
+	err := filepath.Walk(".", func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			switch path {
+			case distDir, binDir, ".git":
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		fmt.Fprintln(h, path)
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(h, f)
+		return err
+	})
+	if err != nil {
+		fatal(err)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func upToDate(hash string) bool {
+	b, err := ioutil.ReadFile(filepath.Join(binDir, buildHashName))
+	return err == nil && string(b) == hash && len(readBinaries(binDir)) > 0
+}
+
+func writeBuildHash(hash string) {
+	err := ioutil.WriteFile(filepath.Join(binDir, buildHashName), []byte(hash), 0644)
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// Path of gox, -gox-path or found on PATH and GOPATH
+func goxBinary() string {
+	if goxPath != "" {
+		return goxPath
+	}
+	gox, err := findGox()
+	if err != nil {
+		fatal("Please install gox before packaging, use: go install github.com/mitchellh/gox@latest")
+	}
+	return gox
+}
+
+// Platforms gox builds when no targets are given, a subset of go tool dist list
+func goxDefaults() []string {
+	out, err := cmdOutput(exec.Command(goxBinary(), "-osarch-list"))
+	if err != nil {
+		fatal(err)
+	}
+	// Platform lines look like "    linux/amd64	(default: true)"
+	var defaults []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[1] == "(default:" && fields[2] == "true)" {
+			defaults = append(defaults, fields[0])
+		}
+	}
+	if len(defaults) == 0 {
+		fatal("Could not list the default gox platforms")
+	}
+	return defaults
+}
+
+func runGox(dir string) {
+	gox := goxBinary()
+	flags := goxArgs(dir)
+	if !windowsGUI {
+		if targets != "" || excludeTargets != "" {
+			flags = append(flags, "-osarch", strings.Join(resolveTargets(), " "))
+		}
+		execGox(gox, flags)
+		return
+	}
+
+	// Windows targets are built separately so the GUI subsystem flag doesn't reach the others
+	guiFlags := withLdflags(flags, "-H windowsgui")
+	if targets == "" && excludeTargets == "" {
+		// Keep gox's default platforms, it negates with !
+		execGox(gox, append(flags, "-os", "!windows"))
+		execGox(gox, append(guiFlags, "-os", "windows"))
+		return
+	}
+	var windows, others []string
+	for _, t := range resolveTargets() {
+		if strings.HasPrefix(t, "windows/") {
+			windows = append(windows, t)
+		} else {
+			others = append(others, t)
+		}
+	}
+	if len(others) > 0 {
+		execGox(gox, append(flags, "-osarch", strings.Join(others, " ")))
+	}
+	if len(windows) > 0 {
+		execGox(gox, append(guiFlags, "-osarch", strings.Join(windows, " ")))
+	}
+}
+
+// Execute gox directly, flags and values are separate arguments so nothing is quoted.
+// Extra flags come first so gop's output template wins.
+func goxArgs(dir string) []string {
+	extra, err := splitArgs(goxFlags)
+	if err != nil {
+		fatalf("Could not parse -gox-flags: %v\n", err)
+	}
+	return append(extra,
+		"-output", filepath.Join(dir, "{{.Dir}}-{{.OS}}-{{.Arch}}"),
+		"-parallel="+strconv.Itoa(buildParallel),
+	)
+}
+
+// Split arguments like a shell, example: -ldflags="-s -w" is the single argument -ldflags=-s -w
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var b strings.Builder
+	// Whether an argument started, "" is an empty argument
+	started := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			// Inside double quotes only a few characters are escaped
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				b.WriteRune('\\')
+			}
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			started = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			started = true
+		case unicode.IsSpace(r):
+			if started {
+				args = append(args, b.String())
+				b.Reset()
+				started = false
+			}
+		default:
+			b.WriteRune(r)
+			started = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in %s", s)
+	}
+	if started {
+		args = append(args, b.String())
+	}
+	return args, nil
+}
+
+func execGox(gox string, flags []string) {
+	cmd := exec.Command(gox, flags...)
+	if len(buildEnv) > 0 {
+		cmd.Env = append(os.Environ(), buildEnv...)
+	}
+	if err := runCmd(cmd); err != nil {
+		printf("gox errors ^\n")
+		emit(event{Event: "warning", Message: "gox errors"})
+	}
+}
+
+// Copy of the gox flags with ldflags appended to any given with -gox-flags
+func withLdflags(flags []string, ldflags string) []string {
+	out := make([]string, len(flags))
+	copy(out, flags)
+	for i, f := range out {
+		switch {
+		case strings.HasPrefix(f, "-ldflags="):
+			out[i] = f + " " + ldflags
+			return out
+		case f == "-ldflags" && i+1 < len(out):
+			out[i+1] += " " + ldflags
+			return out
+		}
+	}
+	return append(out, "-ldflags", ldflags)
+}
+
+// Check a gox path given with -gox-path is an executable file
+func checkGoxPath() {
+	info, err := os.Stat(goxPath)
+	if err != nil {
+		fatal(err)
+	}
+	if info.IsDir() || (runtime.GOOS != "windows" && info.Mode()&0111 == 0) {
+		fatalf("%s is not executable\n", goxPath)
+	}
+}
+
+// Find gox in PATH or the bin directory of any GOPATH entry
+func findGox() (string, error) {
+	if gox, err := exec.LookPath("gox"); err == nil {
+		return gox, nil
+	}
+	name := "gox"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	for _, p := range filepath.SplitList(build.Default.GOPATH) {
+		gox := filepath.Join(p, "bin", name)
+		if info, err := os.Stat(gox); err == nil && !info.IsDir() {
+			return gox, nil
+		}
+	}
+	return "", exec.ErrNotFound
+}
+
+// Module directories used by go.work
+func workspaceModules() []string {
+	f, err := os.Open("go.work")
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+	var dirs []string
+	block := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		switch {
+		case block && line == ")":
+			block = false
+		case block && line != "":
+			dirs = append(dirs, strings.Trim(line, `"`))
+		case line == "use (":
+			block = true
+		case strings.HasPrefix(line, "use "):
+			dirs = append(dirs, strings.Trim(strings.TrimSpace(line[4:]), `"`))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fatal(err)
+	}
+	return dirs
+}
+
+// Run gop in every workspace module changed since the latest tag, every module without tags
+func runWorkspace(cmdArgs []string) {
+	exe, err := os.Executable()
+	if err != nil {
+		fatal(err)
+	}
+	// The same flags without -workspace, -dir last so it wins
+	var args []string
+	for _, a := range cmdArgs {
+		switch a {
+		case "-workspace", "--workspace", "-workspace=true", "--workspace=true":
+			continue
+		}
+		args = append(args, a)
+	}
+	prev, tagErr := describeTag()
+	for _, dir := range workspaceModules() {
+		if tagErr == nil {
+			out, err := cmdOutput(exec.Command("git", "diff", "--name-only", prev+"..HEAD", "--", dir))
+			if err != nil {
+				fatal(err)
+			}
+			if len(strings.TrimSpace(string(out))) == 0 {
+				vprintf("%s unchanged since %s\n", dir, prev)
+				continue
+			}
+		}
+		printf("\n\U0001F4E6 Module %s\n", dir)
+		cmd := exec.Command(exe, append(args, "-dir", dir)...)
+		// The module's own output is already decorated or JSON events
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fatalf("Module %s failed: %v\n", dir, err)
+		}
+		emit(event{Event: "module", Asset: dir, Status: "done"})
+	}
+}
+
+// Print the versions of the external tools, gox has no version so its path is printed
+func toolVersions() {
+	gox := goxPath
+	if gox == "" {
+		gox, _ = findGox()
+	}
+	if gox == "" {
+		vprintf("gox: not found\n")
+	} else {
+		vprintf("gox: %s\n", gox)
+	}
+	for _, tool := range []string{"gh", "git"} {
+		cmd := exec.Command(tool, "--version")
+		cmd.Stderr = ioutil.Discard
+		out, err := cmdOutput(cmd)
+		if err != nil {
+			vprintf("%s: not found\n", tool)
+			continue
+		}
+		// gh prints its release url on a second line
+		vprintf("%s: %s\n", tool, strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0]))
+	}
+}
+
+func resolveTargets() []string {
+	if targets != "" && excludeTargets == "" {
+		return splitList(targets)
+	}
+	// Exclusions are taken from gox's defaults, not every platform the toolchain supports
+	known := distList()
+	var resolved []string
+	if targets != "" {
+		resolved = splitList(targets)
+	} else {
+		resolved = goxDefaults()
+	}
+	// Exclude targets, every exclusion must be a known platform
+	exclude := make(map[string]struct{})
+	for _, t := range splitList(excludeTargets) {
+		if !contains(known, t) {
+			fatalf("Unknown target to exclude: %s\n", t)
+		}
+		exclude[t] = struct{}{}
+	}
+	var filtered []string
+	for _, t := range resolved {
+		if _, ok := exclude[t]; !ok {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+func distList() []string {
+	out, err := cmdOutput(exec.Command("go", "tool", "dist", "list"))
+	if err != nil {
+		fatal(err)
+	}
+	return strings.Fields(string(out))
+}
+
+func contains(a []string, s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+func requireTool(name string, install string) {
+	if _, err := exec.LookPath(name); err != nil {
+		fatalf("Please install %s, use: %s\n", name, install)
+	}
+}
+
+// Run go vet and go test, failing on the first error
+func check() {
+	for _, args := range [][]string{{"vet", "./..."}, {"test", "./..."}} {
+		printf("\nRunning go %s:\n\n", args[0])
+		if err := runCmd(exec.Command("go", args...)); err != nil {
+			fatalf("go %s failed: %v\n", args[0], err)
+		}
+		emit(event{Event: "check", Asset: args[0], Status: "done"})
+	}
+}
+
+// Run a shell command from the project root, aborting on failure
+func runHook(name string, command string, env []string) {
+	printf("\nRunning %s hook:\n\n", name)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("powershell", "-Command", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	if err := runCmd(cmd); err != nil {
+		fatalf("%s hook failed: %v\n", name, err)
+	}
+	emit(event{Event: "hook", Asset: name, Status: "done"})
+}
+
+func runCmd(cmd *exec.Cmd) (err error) {
+	cmd.Stdout = stdout()
+	cmd.Stderr = stdout()
+	err = runTimeout(cmd)
+	if err != nil {
+		return
+	}
+	return
+}
+
+func cmdOutput(cmd *exec.Cmd) ([]byte, error) {
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	err := runTimeout(cmd)
+	return b.Bytes(), err
+}
+
+// Run a command, killing it once the timeout expires
+func runTimeout(cmd *exec.Cmd) error {
+	if timeout <= 0 {
+		return cmd.Run()
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		cmd.Process.Kill()
+	})
+	err := cmd.Wait()
+	if !timer.Stop() {
+		return fmt.Errorf("%s timed out after %v", filepath.Base(cmd.Path), timeout)
+	}
+	return err
+}
+
+func collect(files map[string]string, vend string) {
+	if _, err := os.Stat(vend); !os.IsNotExist(err) {
+		used, err := usedModules()
+		if err != nil {
+			vprintf("Could not list dependencies, collecting every vendored license: %v\n", err)
+		}
+		var modules []string
+		if licenseNaming == "module" {
+			modules = vendoredModules(vend)
+		}
+		funcWalk(vend, func(root string, path string, info fs.FileInfo) {
+			name := strings.ToLower(info.Name())
+			if used != nil && !shipped(used, root, path) {
+				return
+			}
+			if !isLicense(name) {
+				return
+			}
+			if licenseExcluded(root, path) {
+				vprintf("Excluding %s\n", path)
+				return
+			}
+			if base := moduleLicenseName(modules, root, path); base != "" {
+				files[filepath.Join(licenseDir, base)] = path
+				return
+			}
+			// Parent
+			pPath := filepath.Dir(path)
+			pName := filepath.Base(pPath)
+			// Grand parent
+			gpPath := filepath.Dir(pPath)
+			gpName := filepath.Base(gpPath)
+			// Desired base name
+			base := strings.Join([]string{gpName, pName, name}, "-")
+			files[filepath.Join(licenseDir, base)] = path
+		})
+	}
+}
+
+// Print the collected dependency licenses per type and the modules under the GPL or AGPL
+func licenseSummary(files map[string]string, vend string) {
+	modules := vendoredModules(vend)
+	counts := make(map[string]int)
+	var total int
+	var copyleft []string
+	for _, from := range files {
+		rel, err := filepath.Rel(vend, from)
+		if err != nil || strings.HasPrefix(rel, "..") || isNotice(filepath.Base(from)) {
+			continue
+		}
+		id := detectLicense(from)
+		counts[id]++
+		total++
+		if strings.HasPrefix(id, "GPL-") || strings.HasPrefix(id, "AGPL-") {
+			// Module of the license, its directory when not listed
+			dir := filepath.ToSlash(filepath.Dir(rel))
+			module := dir
+			for _, m := range modules {
+				if dir == m || strings.HasPrefix(dir, m+"/") {
+					module = m
+					break
+				}
+			}
+			copyleft = append(copyleft, module+" ("+id+")")
+		}
+	}
+	ids := make([]string, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	printf("Collected %d dependency licenses\n", total)
+	for _, id := range ids {
+		printf("  %s: %d\n", id, counts[id])
+	}
+	if len(copyleft) > 0 {
+		sort.Strings(copyleft)
+		eprintf("\n\u2757 Dependencies under the GPL or AGPL, review before releasing:\n")
+		for _, m := range copyleft {
+			eprintf("  %s\n", m)
+		}
+		eprintf("\n")
+	}
+}
+
+// Whether a vendored license or one of its directories matches -license-exclude
+func licenseExcluded(vend string, file string) bool {
+	rel, err := filepath.Rel(vend, file)
+	if err != nil {
+		return false
+	}
+	patterns := splitList(licenseExclude)
+	for p := filepath.ToSlash(rel); p != "."; p = path.Dir(p) {
+		// Patterns may include the vendor directory or not
+		full := filepath.ToSlash(filepath.Join(vend, p))
+		for _, pattern := range patterns {
+			a, _ := path.Match(pattern, p)
+			b, _ := path.Match(pattern, full)
+			if a || b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Vendored module paths listed in modules.txt, longest first
+func vendoredModules(vend string) []string {
+	f, err := os.Open(filepath.Join(vend, "modules.txt"))
+	if err != nil {
+		vprintf("Could not read vendored modules, using legacy license names: %v\n", err)
+		return nil
+	}
+	defer f.Close()
+	var modules []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Module lines look like "# <path> <version>"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[0] == "#" {
+			modules = append(modules, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fatal(err)
+	}
+	sort.Slice(modules, func(i, j int) bool { return len(modules[i]) > len(modules[j]) })
+	return modules
+}
+
+// License name from the module path, example: golang.org_x_text_LICENSE, empty if no module matches
+func moduleLicenseName(modules []string, vend string, path string) string {
+	dir, err := filepath.Rel(vend, filepath.Dir(path))
+	if err != nil {
+		return ""
+	}
+	dir = filepath.ToSlash(dir)
+	for _, m := range modules {
+		if dir == m || strings.HasPrefix(dir, m+"/") {
+			// Licenses of nested directories keep their path within the module
+			return moduleNameReplacer.Replace(dir) + "_" + filepath.Base(path)
+		}
+	}
+	return ""
+}
+
+var moduleNameReplacer = strings.NewReplacer("/", "_", ".", "_")
+
+// Modules with packages imported by the main package, test dependencies excluded
+func usedModules() (map[string]bool, error) {
+	cmd := exec.Command("go", "list", "-deps", "-f", "{{with .Module}}{{.Path}}{{end}}", ".")
+	cmd.Stderr = ioutil.Discard
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		return nil, err
+	}
+	used := map[string]bool{}
+	for _, m := range strings.Fields(string(out)) {
+		used[m] = true
+	}
+	return used, nil
+}
+
+// Whether the vendored file belongs to a used module
+func shipped(used map[string]bool, vend string, path string) bool {
+	dir, err := filepath.Rel(vend, filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	dir = filepath.ToSlash(dir)
+	for m := range used {
+		if dir == m || strings.HasPrefix(dir, m+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Concatenate collected notices with module headers, removing the copies with -notices-only
+func aggregate(files map[string]string) string {
+	var keys []string
+	for to, from := range files {
+		if isNotice(filepath.Base(from)) {
+			keys = append(keys, to)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, to := range keys {
+		from := files[to]
+		content, err := ioutil.ReadFile(from)
+		if err != nil {
+			fatal(err)
+		}
+		module, err := filepath.Rel(vendorDir, filepath.Dir(from))
+		if err != nil {
+			fatal(err)
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("================================================================================\n")
+		b.WriteString(filepath.ToSlash(module) + "\n")
+		b.WriteString("================================================================================\n\n")
+		b.Write(content)
+		if noticesOnly {
+			delete(files, to)
+		}
+	}
+	return b.String()
+}
+
+func isNotice(fname string) bool {
+	return strings.ToLower(strings.TrimSuffix(fname, filepath.Ext(fname))) == "notice"
+}
+
+func funcWalk(dir string, f walkFunc) {
+	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Skip paths, names only at the top level so nested directories such as a module's assets are walked
+		_, a := noWalk[info.Name()]
+		a = a && filepath.Dir(path) == filepath.Clean(dir)
+		_, b := noWalk[filepath.Ext(info.Name())]
+		if a || b {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		// Run func
+		f(dir, path, info)
+		return nil
+	})
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// Project URL from the module path, on -host when set
+func projectURL() string {
+	if host == "" {
+		return protocol + modulePath
+	}
+	p := modulePath
+	if i := strings.Index(p, "/"); i >= 0 {
+		p = p[i:]
+	} else {
+		p = ""
+	}
+	return protocol + host + p
+}
+
+// Repository URL of the release, -gh-repo when set or the root of the module's repository
+func releaseRepoURL() string {
+	if ghRepo != "" {
+		// gh takes [HOST/]OWNER/REPO, github.com when there's no host
+		if strings.Count(ghRepo, "/") >= 2 {
+			return protocol + ghRepo
+		}
+		h := host
+		if h == "" {
+			h = "github.com"
+		}
+		return protocol + h + "/" + ghRepo
+	}
+	// Repository root, the module may be nested
+	parts := strings.SplitN(strings.TrimPrefix(projectURL(), protocol), "/", 4)
+	if len(parts) < 3 {
+		return projectURL()
+	}
+	return protocol + strings.Join(parts[:3], "/")
+}
+
+func readme(name string) string {
+	var b strings.Builder
+	b.WriteString("Thank you for downloading ")
+	b.WriteString(strings.Title(name))
+	b.WriteString("\n")
+	b.WriteString("If you would like to contribute and/or download the source code, visit:\n")
+	b.WriteString(readmeURL())
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Source code URL in the readme
+func readmeURL() string {
+	if repoURL != "" {
+		return repoURL
+	}
+	return projectURL()
+}
+
+// Warn when a URL doesn't respond with a success or redirect
+func checkLink(link string) {
+	vprintf("Checking %s\n", link)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Head(link)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 400 {
+			return
+		}
+		err = errors.New(resp.Status)
+	}
+	// The URL is already printed
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+	eprintf("\n\u2757 %s is not reachable: %v\n", link, err)
+	emit(event{Event: "warning", URL: link, Message: err.Error()})
+}
+
+// Create and push the version tag unless it exists, the local tag is removed on failure
+// Reports whether the tag was created
+func tag() bool {
+	verify := exec.Command("git", "rev-parse", "-q", "--verify", "refs/tags/"+version)
+	if _, err := cmdOutput(verify); err == nil {
+		vprintf("Tag %s exists\n", version)
+		return false
+	}
+	printf("\U0001F516 %s\n", version)
+	err := runCmd(exec.Command("git", "tag", version))
+	if err != nil {
+		fatalf("Could not create tag %s: %v\n", version, err)
+	}
+	err = runCmd(exec.Command("git", "push", "origin", version))
+	if err != nil {
+		deleteTag(false)
+		fatalf("Could not push tag %s: %v\n", version, err)
+	}
+	emit(event{Event: "tag", Asset: version, Status: "created"})
+	return true
+}
+
+func deleteTag(remote bool) {
+	if remote {
+		if err := runCmd(exec.Command("git", "push", "--delete", "origin", version)); err != nil {
+			eprintf("\n\u2757 Could not delete remote tag: %s\n", version)
+		}
+	}
+	if err := runCmd(exec.Command("git", "tag", "-d", version)); err != nil {
+		eprintf("\n\u2757 Could not delete local tag: %s\n", version)
+		return
+	}
+	emit(event{Event: "tag", Asset: version, Status: "deleted"})
+}
+
+func latestTag() string {
+	tag, err := describeTag()
+	if err != nil {
+		fatal("No git tags found, please tag a version or use -version-source changelog")
+	}
+	return tag
+}
+
+func describeTag(rev ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"describe", "--tags", "--abbrev=0"}, rev...)...)
+	cmd.Stderr = ioutil.Discard
+	out, err := cmdOutput(cmd)
+	return strings.TrimSpace(string(out)), err
+}
+
+// Compare the go.mod go version against the local toolchain
+func checkGoVersion() {
+	toolchain := toolchainVersion()
+	if toolchain == "" {
+		toolchain = runtime.Version()
+	}
+	have, ok := parseGoVersion(toolchain)
+	want, wantOk := parseGoVersion(goVersion)
+	// Development toolchains aren't comparable
+	if !ok || !wantOk {
+		return
+	}
+	for i := range want {
+		if have[i] > want[i] {
+			return
+		}
+		if have[i] < want[i] {
+			msg := fmt.Sprintf("Go toolchain %s is older than go %s required by go.mod", toolchain, goVersion)
+			if minGo == "fail" {
+				fatal(msg)
+			}
+			eprintf("\n\u2757 %s\n", msg)
+			emit(event{Event: "warning", Message: msg})
+			return
+		}
+	}
+}
+
+// Major, minor and patch of a go version such as go1.21.5 or 1.22, pre-releases count as their release
+func parseGoVersion(v string) ([3]int, bool) {
+	var n [3]int
+	v = strings.TrimPrefix(v, "go")
+	if i := strings.IndexAny(v, "rb- "); i >= 0 {
+		v = v[:i]
+	}
+	a := strings.Split(v, ".")
+	if len(a) > 3 {
+		return n, false
+	}
+	for i, p := range a {
+		x, err := strconv.Atoi(p)
+		if err != nil {
+			return n, false
+		}
+		n[i] = x
+	}
+	return n, true
+}
+
+// Compare the changelog version against the git tags, the version's tag is usually created when releasing
+func checkTag() {
+	var msg string
+	cmd := exec.Command("git", "tag", "--points-at", "HEAD")
+	cmd.Stderr = ioutil.Discard
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		return
+	}
+	if tags := strings.Fields(string(out)); len(tags) > 0 {
+		if contains(tags, version) {
+			return
+		}
+		msg = fmt.Sprintf("Changelog version %s does not match HEAD tagged %s", version, strings.Join(tags, ", "))
+	} else {
+		tag, err := describeTag()
+		if err != nil {
+			return
+		}
+		if c, ok := compareVersions(version, tag); !ok || c > 0 {
+			return
+		}
+		msg = fmt.Sprintf("Changelog version %s is not newer than latest git tag %s", version, tag)
+	}
+	if checkTagMode == "fail" {
+		fatal(msg)
+	}
+	eprintf("\n\u2757 %s\n", msg)
+	emit(event{Event: "warning", Message: msg})
+}
+
+// Semantic version precedence of a and b, -1, 0 or 1, false if either isn't semantic
+func compareVersions(a string, b string) (int, bool) {
+	an, apre, aok := parseSemver(a)
+	bn, bpre, bok := parseSemver(b)
+	if !aok || !bok {
+		return 0, false
+	}
+	for i := range an {
+		if an[i] != bn[i] {
+			return sign(an[i] - bn[i]), true
+		}
+	}
+	// A release is newer than its pre-releases
+	switch {
+	case apre == bpre:
+		return 0, true
+	case apre == "":
+		return 1, true
+	case bpre == "":
+		return -1, true
+	}
+	ai, bi := strings.Split(apre, "."), strings.Split(bpre, ".")
+	for i := 0; i < len(ai) && i < len(bi); i++ {
+		if ai[i] == bi[i] {
+			continue
+		}
+		// Numeric identifiers are compared numerically and are older than others
+		x, xerr := strconv.Atoi(ai[i])
+		y, yerr := strconv.Atoi(bi[i])
+		switch {
+		case xerr == nil && yerr == nil:
+			return sign(x - y), true
+		case xerr == nil:
+			return -1, true
+		case yerr == nil:
+			return 1, true
+		case ai[i] < bi[i]:
+			return -1, true
+		default:
+			return 1, true
+		}
+	}
+	return sign(len(ai) - len(bi)), true
+}
+
+// Major, minor, patch and pre-release of a version such as v1.2.3-rc.1, build metadata is ignored
+func parseSemver(v string) ([3]int, string, bool) {
+	var n [3]int
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+	var pre string
+	if i := strings.Index(v, "-"); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+	a := strings.Split(v, ".")
+	if len(a) != 3 {
+		return n, "", false
+	}
+	for i, p := range a {
+		x, err := strconv.Atoi(p)
+		if err != nil || x < 0 {
+			return n, "", false
+		}
+		n[i] = x
+	}
+	return n, pre, true
+}
+
+func sign(x int) int {
+	switch {
+	case x < 0:
+		return -1
+	case x > 0:
+		return 1
+	}
+	return 0
+}
+
+// Whether a version has a semver pre-release segment or an alpha, beta or rc marker
+func isPrerelease(v string) bool {
+	v = strings.ToLower(strings.TrimPrefix(v, "v"))
+	// Build metadata isn't part of the pre-release
+	if i := strings.Index(v, "+"); i >= 0 {
+		v = v[:i]
+	}
+	if strings.Contains(v, "-") {
+		return true
+	}
+	for _, marker := range []string{"alpha", "beta", "rc"} {
+		if strings.Contains(v, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conventional commit type and its notes heading
+type commitType struct {
+	Type    string
+	Heading string
+}
+
+// Notes headings in order, other types are grouped last
+var commitTypes = []commitType{
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"perf", "Performance"},
+	{"refactor", "Refactoring"},
+	{"docs", "Documentation"},
+}
+
+// Release notes from the commit subjects since the tag before v, grouped by conventional commit type
+func commitNotes(v string) string {
+	prev, err := describeTag()
+	if err == nil && prev == v {
+		prev, err = describeTag(v + "^")
+	}
+	args := []string{"log", "--no-merges", "--format=%h %s"}
+	if err == nil && prev != "" {
+		args = append(args, prev+"..HEAD")
+	}
+	out, err := cmdOutput(exec.Command("git", args...))
+	if err != nil {
+		fatal(err)
+	}
+	groups := map[string][]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		// TrimSpace drops the separator of a last commit without a subject
+		parts := strings.SplitN(line, " ", 2)
+		hash, subject := parts[0], ""
+		if len(parts) == 2 {
+			subject = parts[1]
+		}
+		typ := "other"
+		// type(scope)!: subject
+		if j := strings.Index(subject, ": "); j > 0 {
+			t := strings.TrimSuffix(subject[:j], "!")
+			if k := strings.Index(t, "("); k >= 0 {
+				t = t[:k]
+			}
+			for _, ct := range commitTypes {
+				if strings.EqualFold(t, ct.Type) {
+					typ = ct.Type
+					subject = subject[j+2:]
+				}
+			}
+		}
+		item := "(" + hash + ")"
+		if subject != "" {
+			item = subject + " " + item
+		}
+		groups[typ] = append(groups[typ], "- "+item)
+	}
+	var b strings.Builder
+	for _, ct := range append(commitTypes, commitType{"other", "Other Changes"}) {
+		if len(groups[ct.Type]) == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("### " + ct.Heading + "\n")
+		b.WriteString(strings.Join(groups[ct.Type], "\n") + "\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Increment the current version and add its changelog section, see -bump-commit
+func bump(part string) {
+	if !contains([]string{"patch", "minor", "major"}, part) {
+		fatal("Please choose what to bump, use: gop bump patch|minor|major")
+	}
+	current := ""
+	if versionSource == "git" {
+		current = latestTag()
+	} else {
+		changes(logName, "")
+		current = version
+	}
+	next, err := bumpVersion(current, part)
+	if err != nil {
+		fatal(err)
+	}
+
+	// Insert the section before the first version, after any title
+	content, err := ioutil.ReadFile(logName)
+	if err != nil && !os.IsNotExist(err) {
+		fatal(err)
+	}
+	lines := strings.Split(string(content), "\n")
+	date := time.Now().Format("2006-01-02")
+	section := []string{"# " + next, "<!-- " + date + " -->", ""}
+	i := 0
+	if changelogFormat == "keep-a-changelog" {
+		// Unreleased changes become the version's
+		section = []string{"## [" + next + "] - " + date}
+		for ; i < len(lines); i++ {
+			t := strings.TrimSuffix(lines[i], "\r")
+			if strings.HasPrefix(t, "## ") {
+				if strings.Contains(strings.ToLower(t), "unreleased") {
+					i++
+					section = append([]string{""}, section...)
+				} else {
+					section = append(section, "")
+				}
+				break
+			}
+		}
+	} else {
+		for ; i < len(lines); i++ {
+			t := strings.TrimSuffix(lines[i], "\r")
+			if len(t) > 2 && t[0:2] == "# " && !isChangelogTitle(strings.TrimSpace(t[1:])) {
+				break
+			}
+		}
+	}
+	lines = append(lines[:i], append(section, lines[i:]...)...)
+	err = ioutil.WriteFile(logName, []byte(strings.Join(lines, "\n")), 0644)
+	if err != nil {
+		fatal(err)
+	}
+	printf("\U0001F516 %s -> %s\n", current, next)
+	emit(event{Event: "bump", Asset: next, Status: "bumped"})
+
+	if bumpCommit {
+		err := runCmd(exec.Command("git", "commit", "-m", "Bump version to "+next, "--", logName))
+		if err != nil {
+			fatal(err)
+		}
+	}
+}
+
+// Semantic version with the part incremented, pre-releases bump to their release first
+func bumpVersion(v string, part string) (string, error) {
+	prefix := ""
+	if strings.HasPrefix(v, "v") {
+		prefix = "v"
+	}
+	s := strings.TrimPrefix(v, prefix)
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	pre := false
+	if i := strings.Index(s, "-"); i >= 0 {
+		s = s[:i]
+		pre = true
+	}
+	a := strings.Split(s, ".")
+	if len(a) != 3 {
+		return "", fmt.Errorf("version %s is not semantic, example: v1.2.3", v)
+	}
+	var n [3]int
+	for i, p := range a {
+		x, err := strconv.Atoi(p)
+		if err != nil || x < 0 {
+			return "", fmt.Errorf("version %s is not semantic, example: v1.2.3", v)
+		}
+		n[i] = x
+	}
+	switch part {
+	case "major":
+		if !pre || n[1] != 0 || n[2] != 0 {
+			n[0]++
+		}
+		n[1], n[2] = 0, 0
+	case "minor":
+		if !pre || n[2] != 0 {
+			n[1]++
+		}
+		n[2] = 0
+	case "patch":
+		if !pre {
+			n[2]++
+		}
+	}
+	return fmt.Sprintf("%s%d.%d.%d", prefix, n[0], n[1], n[2]), nil
+}
+
+func checkClean() {
+	out, err := cmdOutput(exec.Command("git", "status", "--porcelain"))
+	if err != nil {
+		fatal(err)
+	}
+	dirty := strings.TrimRight(string(out), "\n")
+	if dirty != "" {
+		fatalf("Working tree has uncommitted changes, commit them or use -allow-dirty:\n%s\n", dirty)
+	}
+}
+
+func release(dir string) {
+	var assets []fs.FileInfo
+	if packFlag {
+		var err error
+		assets, err = ioutil.ReadDir(dir)
+		if err != nil {
+			fatal(err)
+		}
+		if len(assets) <= 0 {
+			eprintf("No assets in %s directory\n", dir)
+			emit(event{Event: "release", Status: "skipped", Message: "No assets in " + dir + " directory"})
+			stop(0, "")
+		}
+		if listAssets && !confirmAssets(dir, assets) {
+			fatal("Release cancelled")
+		}
+	}
+
+	printf("\nReleasing:\n\n")
+	// Resume uploading to an existing release, skipping assets uploaded with the same size
+	resuming := false
+	// Assets uploaded with another size, replaced when resuming
+	replace := map[string]bool{}
+	if resume && !force {
+		if uploaded, err := releaseAssets(); err == nil {
+			resuming = true
+			printf("\U0001F3F7 %s exists, resuming\n", version)
+			sizes := map[string]int64{}
+			for _, a := range uploaded {
+				sizes[a.Name] = a.Size
+			}
+			var missing []fs.FileInfo
+			for _, a := range assets {
+				if size, ok := sizes[a.Name()]; ok {
+					if size == a.Size() {
+						vprintf("\u2705 %s already uploaded\n", a.Name())
+						continue
+					}
+					replace[a.Name()] = true
+				}
+				missing = append(missing, a)
+			}
+			assets = missing
+		}
+	}
+	if !resuming {
+		createRelease()
+	}
+
+	if packFlag {
+		printf("\nUploading Assets~\n\n")
+		if !upload(dir, assets, replace) {
+			eprintf("\n\u2757 Could not upload assets: %s\n", version)
+			emit(event{Event: "upload", Asset: version, Status: "failed"})
+			// Keep the release to resume
+			if resume {
+				eprintf("\nRun again with -resume to upload the remaining assets\n")
+				stop(1, "Could not upload assets: "+version)
+			}
+			// Cleanup
+			printf("\nDeleting release...\n")
+			args := []string{"release", "delete", version}
+			cmd := gh(args...)
+			err := runCmd(cmd)
+			if err != nil {
+				eprintf("\n\u2757 Could not delete release: %s\n", version)
+				emit(event{Event: "release", Asset: version, Status: "delete failed"})
+				stop(0, "Could not upload assets: "+version)
+			}
+			printf("\n\u2705 Release deleted\n")
+			emit(event{Event: "release", Asset: version, Status: "deleted"})
+			printf("\nDeleting remote tag...\n")
+			args = []string{"push", "--delete", "origin", version}
+			cmd = exec.Command("git", args...)
+			err = runCmd(cmd)
+			if err != nil {
+				eprintf("\n\u2757 Could not delete remote tag: %s\n", version)
+				emit(event{Event: "tag", Asset: version, Status: "delete failed"})
+				stop(0, "Could not upload assets: "+version)
+			}
+			printf("\n\u2705 Remote tag deleted\n")
+			emit(event{Event: "tag", Asset: version, Status: "deleted"})
+			stop(0, "Could not upload assets: "+version)
+		}
+
+		// Download urls
+		printDownloads()
+	}
+}
+
+// Release notes, the changelog wrapped in the header and footer
+func notes() string {
+	var parts []string
+	if notesHeader != "" {
+		parts = append(parts, fileOrText(notesHeader))
+	}
+	parts = append(parts, strings.TrimRight(changelog, "\n"))
+	if url := compareURL(); url != "" {
+		parts = append(parts, "**Full Changelog**: "+url)
+	}
+	if notesFooter != "" {
+		parts = append(parts, fileOrText(notesFooter))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// Link comparing the previous tag with the version, empty without a previous tag
+func compareURL() string {
+	prev, err := describeTag("HEAD^")
+	if err != nil || prev == "" || prev == version {
+		return ""
+	}
+	repo := releaseRepoURL()
+	if strings.HasPrefix(repo, protocol+"gitlab.com/") {
+		return repo + "/-/compare/" + prev + "..." + version
+	}
+	return repo + "/compare/" + prev + "..." + version
+}
+
+// Contents of the file s, s itself if there's no such file
+func fileOrText(s string) string {
+	b, err := ioutil.ReadFile(s)
+	if err != nil {
+		return s
+	}
+	return strings.TrimSuffix(string(b), "\n")
+}
+
+// Print the assets to upload and ask for confirmation unless -yes
+func confirmAssets(dir string, assets []fs.FileInfo) bool {
+	printf("\nAssets to upload from %s:\n\n", dir)
+	for _, a := range assets {
+		printf("%10d  %s\n", a.Size(), a.Name())
+		emit(event{Event: "asset", Asset: a.Name(), Message: strconv.FormatInt(a.Size(), 10) + " bytes"})
+	}
+	if yes {
+		return true
+	}
+	// Prompts go to stderr so they show in JSON mode
+	fmt.Fprint(os.Stderr, "\nUpload these assets? [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Create the release with the notes, and the tag with -create-tag
+func createRelease() {
+	// Delete an existing release
+	if force {
+		deleteRelease()
+	}
+	// Create tag, only one created here is removed on errors
+	tagged := false
+	if createTag {
+		tagged = tag()
+	}
+	// Write changelog to temporary file
+	tmp := tempFile("temp*.md", keepTemp)
+	_, err := io.Copy(tmp, strings.NewReader(notes()))
+	if err != nil {
+		fatal(err)
+	}
+	if keepTemp {
+		printf("Release notes kept in %s\n", tmp.Name())
+	} else {
+		defer os.Remove(tmp.Name())
+	}
+	// Create release
+	printf("\U0001F3F7 %s\n", version)
+	args := []string{"release", "create", version, "-t", version, "-F", tmp.Name()}
+	if prerelease || (autoPrerelease && isPrerelease(version)) {
+		args = append(args, "-p")
+	}
+	if targetCommitish != "" {
+		args = append(args, "--target", targetCommitish)
+	}
+	cmd := gh(args...)
+	err = runCmd(cmd)
+	if err != nil {
+		// Cleanup on errors
+		tmp.Close()
+		if !keepTemp {
+			os.Remove(tmp.Name())
+		}
+		if tagged {
+			deleteTag(true)
+		}
+		fatal(err)
+	}
+	tmp.Close()
+	emit(event{Event: "release", Asset: version, Status: "created"})
+}
+
+// Delete the release of the version and its tag if they exist, see -force
+func deleteRelease() {
+	view := gh("release", "view", version, "--json", "tagName")
+	view.Stderr = ioutil.Discard
+	if _, err := cmdOutput(view); err != nil {
+		return
+	}
+	printf("Deleting existing release...\n")
+	err := runCmd(gh("release", "delete", version, "--yes", "--cleanup-tag"))
+	if err != nil {
+		fatalf("Could not delete release %s: %v\n", version, err)
+	}
+	emit(event{Event: "release", Asset: version, Status: "deleted"})
+	// The tag is created again
+	if createTag {
+		cmd := exec.Command("git", "tag", "-d", version)
+		cmd.Stderr = ioutil.Discard
+		cmdOutput(cmd)
+	}
+	printf("\u2705 Release deleted\n\n")
+}
+
+// Upload assets in parallel, at most -upload-concurrency at a time, replacing those the release has
+func upload(dir string, assets []fs.FileInfo, replace map[string]bool) bool {
+	sem := make(chan struct{}, uploadConcurrency)
+	failed := make([]bool, len(assets))
+	errs := make([]error, len(assets))
+	var wg sync.WaitGroup
+	for i, a := range assets {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			defer catch(&errs[i])
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			file := filepath.Join(dir, name)
+			if lb := labels.label(name); lb != "" {
+				file += "#" + lb
+			}
+			args := []string{"release", "upload", version, file}
+			if replace[name] {
+				args = append(args, "--clobber")
+			}
+			err := runCmd(gh(args...))
+			if err != nil {
+				failed[i] = true
+				eprintf("\u2757 %s\n", name)
+				emit(event{Event: "upload", Asset: name, Status: "failed"})
+				return
+			}
+			printf("\U0001F4EC %s\n", name)
+			emit(event{Event: "upload", Asset: name, Status: "uploaded"})
+		}(i, a.Name())
+	}
+	wg.Wait()
+	failFirst(errs)
+	for _, f := range failed {
+		if f {
+			return false
+		}
+	}
+	return true
+}
+
+// Release as listed by gh
+type ghRelease struct {
+	TagName      string `json:"tagName"`
+	IsPrerelease bool   `json:"isPrerelease"`
+	IsDraft      bool   `json:"isDraft"`
+}
+
+// Delete old pre-releases, and releases with -prune-stable, with their tags beyond the newest -keep
+func prune() {
+	out, err := cmdOutput(gh("release", "list", "--limit", "1000", "--json", "tagName,isPrerelease,isDraft"))
+	if err != nil {
+		fatal(err)
+	}
+	var releases []ghRelease
+	err = json.Unmarshal(out, &releases)
+	if err != nil {
+		fatal(err)
+	}
+	// Releases are listed newest first
+	var old []string
+	kept := 0
+	for _, r := range releases {
+		if r.IsDraft || (!r.IsPrerelease && !pruneStable) {
+			continue
+		}
+		if kept < keepReleases {
+			kept++
+			continue
+		}
+		old = append(old, r.TagName)
+	}
+	if len(old) == 0 {
+		printf("Nothing to prune\n")
+		return
+	}
+	if !yes {
+		printf("Releases to prune:\n\n")
+		for _, t := range old {
+			printf("\U0001F9F9 %s\n", t)
+			emit(event{Event: "prune", Asset: t, Status: "pending"})
+		}
+		printf("\nRun again with -yes to delete them and their tags\n")
+		return
+	}
+	for _, t := range old {
+		err := runCmd(gh("release", "delete", t, "--yes", "--cleanup-tag"))
+		if err != nil {
+			fatal(err)
+		}
+		printf("\U0001F9F9 %s\n", t)
+		emit(event{Event: "prune", Asset: t, Status: "deleted"})
+	}
+}
+
+// Release asset as reported by gh
+type releaseAsset struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	URL  string `json:"url"`
+}
+
+func releaseAssets() ([]releaseAsset, error) {
+	cmd := gh("release", "view", version, "--json", "assets")
+	cmd.Stderr = ioutil.Discard
+	out, err := cmdOutput(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var view struct {
+		Assets []releaseAsset `json:"assets"`
+	}
+	err = json.Unmarshal(out, &view)
+	return view.Assets, err
+}
+
+func printDownloads() {
+	assets, err := releaseAssets()
+	if err != nil {
+		eprintf("\n\u2757 Could not get download urls: %v\n", err)
+		return
+	}
+	printf("\nDownloads:\n\n")
+	for _, a := range assets {
+		printf("\U0001F517 %s: %s\n", a.Name, a.URL)
+		emit(event{Event: "download", Asset: a.Name, URL: a.URL})
+	}
+}
+
+// Github CLI command for the configured repository
+func gh(args ...string) *exec.Cmd {
+	if ghRepo != "" {
+		args = append(args, "--repo", ghRepo)
+	}
+	cmd := exec.Command("gh", args...)
+	var env []string
+	if host != "" {
+		env = append(env, "GH_HOST="+host)
+	}
+	if token != "" {
+		env = append(env, "GH_TOKEN="+token)
+	}
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// ASCII labels for emoji, see -no-emoji
+var asciiLabels = strings.NewReplacer(
+	"\U0001F4E6", "[pack]",
+	"\U0001F3F7", "[release]",
+	"\U0001F4EC", "[upload]",
+	"\U0001F516", "[tag]",
+	"\U0001F517", "[download]",
+	"\U0001F511", "[checksums]",
+	"\U0001F4DD", "[manifest]",
+	"\U0001F37A", "[homebrew]",
+	"\U0001F944", "[scoop]",
+	"\U0001F5DC", "[compress]",
+	"\U0001F4CB", "[sbom]",
+	"\U0001F9F9", "[clean]",
+	"\u270D", "[sign]",
+	"\u2705", "[ok]",
+	"\u2757", "[!]",
+)
+
+// Whether the terminal likely can't render emoji
+func asciiTerminal() bool {
+	if runtime.GOOS == "windows" {
+		// Windows Terminal renders emoji, the legacy console doesn't
+		return os.Getenv("WT_SESSION") == ""
+	}
+	// The first locale variable set wins
+	for _, k := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := strings.ToLower(os.Getenv(k)); v != "" {
+			return !strings.Contains(v, "utf-8") && !strings.Contains(v, "utf8")
+		}
+	}
+	return false
+}
+
+func decorate(format string) string {
+	if noEmoji {
+		return asciiLabels.Replace(format)
+	}
+	return format
+}
+
+// Print a box with the project name and version, ASCII with -no-emoji
+func printBanner() {
+	h, v, tl, tr, bl, br := "\u2500", "\u2502", "\u250C", "\u2510", "\u2514", "\u2518"
+	if noEmoji {
+		h, v, tl, tr, bl, br = "-", "|", "+", "+", "+", "+"
+	}
+	text := projectName + " " + version
+	line := strings.Repeat(h, utf8.RuneCountInString(text)+2)
+	printf("%s%s%s\n%s %s %s\n%s%s%s\n", tl, line, tr, v, text, v, bl, line, br)
+}
+
+// Print decorated output, silent in JSON mode
+func printf(format string, a ...interface{}) {
+	if !jsonFlag {
+		fmt.Printf(decorate(format), a...)
+	}
+}
+
+// Print verbose output, silent in JSON mode
+func vprintf(format string, a ...interface{}) {
+	if verbose {
+		printf(format, a...)
+	}
+}
+
+// Print decorated output to stderr, silent in JSON mode
+func eprintf(format string, a ...interface{}) {
+	if !jsonFlag {
+		fmt.Fprintf(os.Stderr, decorate(format), a...)
+	}
+}
+
+// Print a JSON event, only in JSON mode
+func emit(e event) {
+	if jsonFlag {
+		json.NewEncoder(os.Stdout).Encode(e)
+	}
+}
+
+// Output for external commands, stdout is reserved for events in JSON mode
+func stdout() io.Writer {
+	if jsonFlag {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func fatal(v ...interface{}) {
+	exit(fmt.Sprint(v...))
+}
+
+func fatalf(format string, v ...interface{}) {
+	exit(fmt.Sprintf(format, v...))
+}
+
+// Log an error, as a JSON event in JSON mode, and exit
+// End of a run, Main exits with the code and Pack and Release return it when it has a message
+type exitError struct {
+	code int
+	msg  string
+	// Where the run failed, example: gop.go:42
+	pos string
+	// Already reported while running
+	shown bool
+}
+
+func (e exitError) Error() string {
+	return strings.TrimSpace(e.msg)
+}
+
+// Fail the run, the caller of fatal or fatalf is the position
+func exit(msg string) {
+	pos := ""
+	if _, file, line, ok := runtime.Caller(2); ok {
+		pos = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	panic(exitError{code: 1, msg: msg, pos: pos})
+}
+
+// End the run early with a code, the reason was already reported
+func stop(code int, reason string) {
+	panic(exitError{code: code, msg: reason, shown: true})
+}
+
+// Recover the end of a run into err, deferred directly so recover works
+func catch(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	e, ok := r.(exitError)
+	if !ok {
+		panic(r)
+	}
+	*err = e
+}
+
+// End the run with the first failure recovered from goroutines
+func failFirst(errs []error) {
+	for _, err := range errs {
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Print a failure like the log package would
+func report(e exitError) {
+	if e.shown || e.msg == "" {
+		return
+	}
+	if jsonFlag {
+		emit(event{Event: "error", Status: "failed", Message: e.Error()})
+	} else {
+		logErr.Print(e.pos + ": " + e.msg)
+	}
+}
@@ -0,0 +1,583 @@
+package gop
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	defineFlags(flag.CommandLine)
+	flag.Parse()
+	os.Exit(m.Run())
+}
+
+// Set a flag for one test, restoring its default after
+func setFlag(t *testing.T, name string, value string) {
+	t.Helper()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		flag.Set(name, flag.Lookup(name).DefValue)
+	})
+}
+
+// Fake gox writing a small file per target to the output template
+const fakeGox = `#!/bin/sh
+out=""; osarch="linux/amd64 windows/amd64"
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-output) out="$2"; shift;;
+	-osarch) osarch="$2"; shift;;
+	esac
+	shift
+done
+for t in $osarch; do
+	os=${t%/*}; arch=${t#*/}
+	f=$(echo "$out" | sed "s|{{.Dir}}|my.tool|; s|{{.OS}}|$os|; s|{{.Arch}}|$arch|")
+	[ "$os" = windows ] && f="$f.exe"
+	echo "$t" > "$f"
+done
+`
+
+// Create a project without dependencies and change into it, gox is faked
+func testProject(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake gox is a shell script")
+	}
+	dir := t.TempDir()
+	write := func(name string, content string, mode os.FileMode) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/my.tool\n\ngo 1.16\n", 0644)
+	write("main.go", "package main\n\nfunc main() {}\n", 0644)
+	write("LICENSE", "Permission is hereby granted, free of charge, to any person obtaining a copy\n", 0644)
+	write("gox", fakeGox, 0755)
+	chdir(t, dir)
+	setFlag(t, "gox-path", filepath.Join(dir, "gox"))
+	hasDeps = false
+	projectInfo("go.mod")
+	version = "v1.0.0"
+	outDir = distDir
+	return dir
+}
+
+// Names of the entries of a zip
+func zipEntries(t *testing.T, name string) []string {
+	t.Helper()
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+}
+
+func TestParseBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		want binary
+	}{
+		{"gop-linux-amd64", binary{Name: "gop-linux-amd64", Base: "gop-linux-amd64", OS: "linux", Arch: "amd64"}},
+		{"gop-windows-386.exe", binary{Name: "gop-windows-386.exe", Base: "gop-windows-386", Ext: ".exe", OS: "windows", Arch: "386"}},
+		{"my.tool-linux-amd64", binary{Name: "my.tool-linux-amd64", Base: "my.tool-linux-amd64", OS: "linux", Arch: "amd64"}},
+		{"my.tool-windows-amd64.exe", binary{Name: "my.tool-windows-amd64.exe", Base: "my.tool-windows-amd64", Ext: ".exe", OS: "windows", Arch: "amd64"}},
+		{"my.cool.tool-darwin-arm64", binary{Name: "my.cool.tool-darwin-arm64", Base: "my.cool.tool-darwin-arm64", OS: "darwin", Arch: "arm64"}},
+	}
+	for _, tt := range tests {
+		if got := parseBinary(tt.name); got != tt.want {
+			t.Errorf("parseBinary(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreBuildFailureAbortsPackaging(t *testing.T) {
+	dir := testProject(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, logName), []byte("# v1.0.0\n- Add a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err := Pack(Options{Flags: []string{"-gox-path", filepath.Join(dir, "gox"), "-pre-build", "exit 1"}})
+	if err == nil {
+		t.Fatal("packaging didn't fail")
+	}
+	bins, err := ioutil.ReadDir(filepath.Join(dir, binDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bins) > 0 {
+		t.Errorf("gox ran after the failed hook, built %d binaries", len(bins))
+	}
+}
+
+func TestPack(t *testing.T) {
+	dir := testProject(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, logName), []byte("# v1.2.0\n- Add a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Run from elsewhere, the working directory is restored
+	chdir(t, t.TempDir())
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Pack(Options{
+		Dir:     dir,
+		Targets: []string{"linux/amd64"},
+		Flags:   []string{"-gox-path", filepath.Join(dir, "gox")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.Getwd(); got != wd {
+		t.Errorf("working directory = %s, want %s", got, wd)
+	}
+	if _, err := os.Stat(filepath.Join(dir, distDir, "my.tool-linux-amd64.zip")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPackArchiveHook(t *testing.T) {
+	dir := testProject(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, logName), []byte("# v1.2.0\n- Add a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hook := func(entries []ArchiveEntry) []ArchiveEntry {
+		var kept []ArchiveEntry
+		for _, e := range entries {
+			if e.To != readmeName {
+				kept = append(kept, e)
+			}
+		}
+		return append(kept, ArchiveEntry{To: "VERSION", Content: "v1.2.0"})
+	}
+	err := Pack(Options{
+		Targets:     []string{"linux/amd64"},
+		Flags:       []string{"-gox-path", filepath.Join(dir, "gox")},
+		ArchiveHook: hook,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := zipEntries(t, filepath.Join(dir, distDir, "my.tool-linux-amd64.zip"))
+	sort.Strings(got)
+	want := []string{"VERSION", "licenses-and-notices/my.tool-license", "my.tool"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+}
+
+func TestPackInvalidFlag(t *testing.T) {
+	if err := Pack(Options{Format: "rar"}); err == nil || !strings.Contains(err.Error(), "Unknown archive format: rar") {
+		t.Errorf("err = %v, want the unknown format", err)
+	}
+}
+
+func TestFindGoxSecondGOPATHEntry(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	name := "gox"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	if err := os.MkdirAll(filepath.Join(second, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(second, "bin", name)
+	if err := ioutil.WriteFile(want, []byte(fakeGox), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Not on PATH so only GOPATH is searched
+	path, gopath := os.Getenv("PATH"), build.Default.GOPATH
+	os.Setenv("PATH", t.TempDir())
+	build.Default.GOPATH = first + string(os.PathListSeparator) + second
+	t.Cleanup(func() {
+		os.Setenv("PATH", path)
+		build.Default.GOPATH = gopath
+	})
+	got, err := findGox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("findGox() = %s, want %s", got, want)
+	}
+}
+
+func TestZipBinaryMode(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "my.tool")
+	if err := ioutil.WriteFile(bin, []byte("binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	a := newArchive(&b, ".zip")
+	if err := addFile(a, "my.tool", bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := addString(a, "readme.txt", "readme"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other entries keep the zip default
+	for _, f := range r.File {
+		executable := f.Mode().Perm()&0111 != 0
+		if f.Name == "my.tool" && f.Mode().Perm() != 0755 {
+			t.Errorf("%s mode = %v, want %v", f.Name, f.Mode().Perm(), os.FileMode(0755))
+		} else if f.Name != "my.tool" && executable {
+			t.Errorf("%s is executable", f.Name)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.4", "v1.2.3", 1},
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2.3", "v1.10.0", -1},
+		{"v2.0.0", "v2.0.0-rc.1", 1},
+		{"v2.0.0-rc.2", "v2.0.0-rc.10", -1},
+		{"v2.0.0-rc.1", "v2.0.0-beta", 1},
+		{"v2.0.0-alpha", "v2.0.0-alpha.1", -1},
+		{"1.2.3+build", "v1.2.3", 0},
+	}
+	for _, tt := range tests {
+		if got, ok := compareVersions(tt.a, tt.b); !ok || got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, %v, want %d", tt.a, tt.b, got, ok, tt.want)
+		}
+	}
+	if _, ok := compareVersions("latest", "v1.0.0"); ok {
+		t.Error("compareVersions compared a version that isn't semantic")
+	}
+}
+
+func TestIsTempName(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		want    bool
+	}{
+		{"temp123456.md", "temp*.md", true},
+		{"nfpm42.yaml", "nfpm*.yaml", true},
+		{"template.md", "temp*.md", false},
+		{"temp.md", "temp*.md", false},
+		{"nfpm.yaml", "nfpm*.yaml", false},
+		{"nfpm-prod.yaml", "nfpm*.yaml", false},
+	}
+	for _, tt := range tests {
+		if got := isTempName(tt.name, tt.pattern); got != tt.want {
+			t.Errorf("isTempName(%q, %q) = %v, want %v", tt.name, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestGoxArgsWindowsPath(t *testing.T) {
+	dir := `C:\Users\me\My Projects\tool\bin`
+	args := goxArgs(dir)
+	for i, a := range args {
+		if a != "-output" {
+			continue
+		}
+		want := filepath.Join(dir, "{{.Dir}}-{{.OS}}-{{.Arch}}")
+		if i+1 == len(args) || args[i+1] != want {
+			t.Fatalf("goxArgs(%q) = %q, want -output followed by %q", dir, args, want)
+		}
+		return
+	}
+	t.Fatalf("goxArgs(%q) = %q, missing -output", dir, args)
+}
+
+func TestGoxArgsUnquoted(t *testing.T) {
+	dir := testProject(t)
+	// Record every argument gox receives on its own line
+	record := filepath.Join(dir, "record")
+	script := "#!/bin/sh\nfor a in \"$@\"; do printf '%s\\n' \"$a\" >> args.txt; done\n"
+	if err := ioutil.WriteFile(record, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "gox-path", record)
+	setFlag(t, "targets", "linux/amd64,windows/amd64")
+	setFlag(t, "windows-gui", "true")
+	setFlag(t, "gox-flags", `-cgo -ldflags="-s -w"`)
+	runGox(binDir)
+	b, err := ioutil.ReadFile(filepath.Join(dir, "args.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if strings.ContainsAny(a, `"'`) {
+			t.Errorf("gox received the quoted argument %s", a)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", nil},
+		{"-cgo -rebuild", []string{"-cgo", "-rebuild"}},
+		{`-ldflags="-s -w"`, []string{"-ldflags=-s -w"}},
+		{`-ldflags '-s -w' -cgo`, []string{"-ldflags", "-s -w", "-cgo"}},
+		{`-tags "a b" -x=""`, []string{"-tags", "a b", "-x="}},
+		{`-ldflags="-X main.v=\"1 2\""`, []string{`-ldflags=-X main.v="1 2"`}},
+		{`a\ b  c`, []string{"a b", "c"}},
+		{`''`, []string{""}},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.s)
+		if err != nil {
+			t.Errorf("splitArgs(%q) failed: %v", tt.s, err)
+			continue
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+	for _, s := range []string{`-ldflags="-s`, `-x 'y`, `a\`} {
+		if _, err := splitArgs(s); err == nil {
+			t.Errorf("splitArgs(%q) didn't fail", s)
+		}
+	}
+}
+
+func TestHashParser(t *testing.T) {
+	tests := []struct {
+		name        string
+		log         string
+		want        string
+		wantVersion string
+		wantNotes   string
+	}{
+		{"normal section", "# v1.2.0\n- Add a\n- Fix b\n", "", "v1.2.0", "- Add a\n- Fix b"},
+		{"missing heading", "- Add a\n", "", "", "- Add a"},
+		{"multiple sections", "# v1.2.0\n- Add a\n\n# v1.1.0\n- Add b\n", "", "v1.2.0", "- Add a\n"},
+		{"wanted section", "# v1.2.0\n- Add a\n\n# v1.1.0\n- Add b\n", "v1.1.0", "v1.1.0", "- Add b"},
+		{"missing wanted section", "# v1.2.0\n- Add a\n", "v1.0.0", "", ""},
+		{"empty body", "# v1.2.0\n# v1.1.0\n- Add b\n", "", "v1.2.0", ""},
+		{"crlf", "# v1.2.0\r\n- Add a\r\n- Fix b\r\n", "", "v1.2.0", "- Add a\n- Fix b"},
+		{"trailing whitespace", "# v1.2.0  \t\n- Add a\n", "", "v1.2.0", "- Add a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, notes, err := hashParser{}.parse(strings.NewReader(tt.log), tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("version = %q, want %q", version, tt.wantVersion)
+			}
+			if notes != tt.wantNotes {
+				t.Errorf("notes = %q, want %q", notes, tt.wantNotes)
+			}
+		})
+	}
+}
+
+func TestChangesCRLF(t *testing.T) {
+	dir := t.TempDir()
+	log := "# v1.2.0\r\n- Add a\r\n\r\n# v1.1.0\r\n- Add b\r\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, logName), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, dir)
+	changes(logName, "")
+	if version != "v1.2.0" {
+		t.Errorf("version = %q, want %q", version, "v1.2.0")
+	}
+	if strings.Contains(changelog, "\r") {
+		t.Errorf("changelog = %q, contains a carriage return", changelog)
+	}
+}
+
+func TestCommitNotesEmptySubject(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	chdir(t, t.TempDir())
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.name=gop", "-c", "user.email=gop@example.com", "commit", "-q", "--allow-empty", "--allow-empty-message", "-m", ""},
+		{"-c", "user.name=gop", "-c", "user.email=gop@example.com", "commit", "-q", "--allow-empty", "-m", "feat: add a"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+	notes := commitNotes("v1.0.0")
+	if !strings.Contains(notes, "### Features\n- add a (") {
+		t.Errorf("notes = %q, want the feature", notes)
+	}
+	if !strings.Contains(notes, "### Other Changes\n- (") {
+		t.Errorf("notes = %q, want the commit without a subject", notes)
+	}
+}
+
+func TestHashParserTitle(t *testing.T) {
+	tests := []struct {
+		name        string
+		titles      string
+		log         string
+		wantVersion string
+		wantNotes   string
+	}{
+		{"title", "", "# Changelog\n# v1.2.0\n- Add a\n", "v1.2.0", "- Add a"},
+		{"title and intro", "", "# Changelog\nAll notable changes.\n\n# v1.2.0\n- Add a\n", "v1.2.0", "- Add a"},
+		{"title case", "", "# RELEASE NOTES\n# v1.2.0\n- Add a\n", "v1.2.0", "- Add a"},
+		{"custom title", "History", "# History\n# v1.2.0\n- Add a\n", "v1.2.0", "- Add a"},
+		{"unknown title", "History", "# Changelog\n# v1.2.0\n- Add a\n", "Changelog", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.titles != "" {
+				setFlag(t, "changelog-titles", tt.titles)
+			}
+			version, notes, err := hashParser{}.parse(strings.NewReader(tt.log), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tt.wantVersion {
+				t.Errorf("version = %q, want %q", version, tt.wantVersion)
+			}
+			if notes != tt.wantNotes {
+				t.Errorf("notes = %q, want %q", notes, tt.wantNotes)
+			}
+		})
+	}
+}
+
+func TestWithLdflagsIsolation(t *testing.T) {
+	tests := []struct {
+		flags []string
+		want  []string
+	}{
+		{[]string{"-cgo"}, []string{"-cgo", "-ldflags", "-H windowsgui"}},
+		{[]string{"-ldflags=-s -w", "-cgo"}, []string{"-ldflags=-s -w -H windowsgui", "-cgo"}},
+		{[]string{"-ldflags", "-s -w"}, []string{"-ldflags", "-s -w -H windowsgui"}},
+	}
+	for _, tt := range tests {
+		// Extra capacity so an append in place would show in the original
+		flags := make([]string, len(tt.flags), len(tt.flags)+4)
+		copy(flags, tt.flags)
+		got := withLdflags(flags, "-H windowsgui")
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("withLdflags(%q) = %q, want %q", tt.flags, got, tt.want)
+		}
+		if strings.Join(flags, "|") != strings.Join(tt.flags, "|") || strings.Contains(strings.Join(flags[:cap(flags)], "|"), "windowsgui") {
+			t.Errorf("withLdflags(%q) changed the other targets' flags to %q", tt.flags, flags[:cap(flags)])
+		}
+	}
+}
+
+func TestWindowsGUIOnlyForWindows(t *testing.T) {
+	dir := testProject(t)
+	// Record each gox run on its own line
+	record := filepath.Join(dir, "record")
+	script := "#!/bin/sh\necho \"$*\" >> runs.txt\n"
+	if err := ioutil.WriteFile(record, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, "gox-path", record)
+	setFlag(t, "targets", "linux/amd64,windows/amd64,darwin/arm64")
+	setFlag(t, "windows-gui", "true")
+	setFlag(t, "gox-flags", "-ldflags=-s")
+	runGox(binDir)
+	b, err := ioutil.ReadFile(filepath.Join(dir, "runs.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(runs) != 2 {
+		t.Fatalf("gox ran %d times, want 2:\n%s", len(runs), b)
+	}
+	for _, run := range runs {
+		windows := strings.Contains(run, "windows/amd64")
+		gui := strings.Contains(run, "-H windowsgui")
+		if windows != gui {
+			t.Errorf("windows targets %v but GUI subsystem %v: %s", windows, gui, run)
+		}
+	}
+}
+
+func TestPackWithoutDependencies(t *testing.T) {
+	dir := testProject(t)
+	pack()
+	if _, err := os.Stat(filepath.Join(dir, vendorDir)); !os.IsNotExist(err) {
+		t.Errorf("vendor directory created for a module without dependencies")
+	}
+	// Only the binary, readme and project license
+	want := map[string]string{
+		"my.tool-linux-amd64.zip":   "licenses-and-notices/my.tool-license|my.tool|readme.txt",
+		"my.tool-windows-amd64.zip": "licenses-and-notices/my.tool-license|my.tool.exe|readme.txt",
+	}
+	for name, entries := range want {
+		got := zipEntries(t, filepath.Join(dir, distDir, name))
+		sort.Strings(got)
+		if strings.Join(got, "|") != entries {
+			t.Errorf("%s contains %q, want %s", name, got, entries)
+		}
+	}
+}
+
+// Run with go test -race, every package reads the shared entries at once
+func TestPackConcurrent(t *testing.T) {
+	dir := testProject(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "manual.txt"), []byte("manual"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	includes = includeList{{From: "manual.txt", To: "manual.txt"}}
+	t.Cleanup(func() {
+		includes = nil
+	})
+	targets := []string{"linux/amd64", "linux/arm64", "darwin/amd64", "darwin/arm64", "freebsd/amd64", "windows/amd64"}
+	setFlag(t, "targets", strings.Join(targets, ","))
+	setFlag(t, "archive-checksums", "true")
+	pack()
+	for _, target := range targets {
+		name := "my.tool-" + strings.Replace(target, "/", "-", 1) + ".zip"
+		got := strings.Join(zipEntries(t, filepath.Join(dir, distDir, name)), "|")
+		for _, want := range []string{"manual.txt", "licenses-and-notices/my.tool-license", packSumsName} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s contains %s, missing %s", name, got, want)
+			}
+		}
+	}
+}